@@ -0,0 +1,210 @@
+package main
+
+import "bytes"
+
+// Accumulator aggregates measurements per station. Each worker fills its
+// own Accumulator, and once they finish the workers' accumulators are
+// merged into a fresh one of the same kind.
+//
+// The merged accumulator is written with the configured formatter if it is
+// the default *hashtable; any other implementation must also implement
+// io.WriterTo to produce its output.
+type Accumulator interface {
+	// Update adds a single measurement for key, whose FNV hash is hash.
+	// key aliases the input and stays valid until the output is written.
+	Update(key []byte, hash uint64, temp int32)
+
+	// Merge folds other, which is always of the same concrete type, into
+	// the receiver.
+	Merge(other Accumulator)
+}
+
+// RowRecorder is implemented by accumulators that can also record details
+// of each row beyond its temperature: where in the input each station first
+// and last appears, for -offsets, and the original text of its extremes,
+// for -keep-extremes. The default hashtable also counts readings here for
+// -iqr, carries sums past 64 bits for -wide-sum and notes when the
+// extremes were read for -with-time, keeping all of them off Update's hot
+// path.
+type RowRecorder interface {
+	// UpdateRow is Update for a row starting at byte offset in the input.
+	// text is the temperature as written, which aliases the input, or nil
+	// if the extremes' text isn't wanted. at is the row's timestamp in Unix
+	// nanoseconds, or noTime.
+	UpdateRow(key []byte, hash uint64, temp int32, offset int64, text []byte, at int64)
+}
+
+// Update records a measurement, creating the station's stats on first sight.
+func (ht *hashtable) Update(key []byte, hash uint64, temp int32) {
+	s := ht.get(hash, key)
+	if s == nil {
+		ht.add(hash, key, &stats{min: temp, max: temp, sum: int64(temp), count: 1})
+		return
+	}
+	if temp < s.min {
+		s.min = temp
+	}
+	if temp > s.max {
+		s.max = temp
+	}
+	s.sum += int64(temp)
+	s.count++
+}
+
+// UpdateRow records a measurement along with the offset of its row and,
+// if text is set, a copy of the text of any new min or max. A reading that
+// only ties the current min or max doesn't replace its text, so the
+// earliest of equal readings is kept.
+func (ht *hashtable) UpdateRow(key []byte, hash uint64, temp int32, offset int64, text []byte, at int64) {
+	s := ht.get(hash, key)
+	if s == nil {
+		s = &stats{min: temp, max: temp, sum: int64(temp), count: 1, first: offset, last: offset}
+		if text != nil {
+			t := bytes.Clone(text)
+			s.extremes = &extremeText{min: t, max: t}
+		}
+		if ht.times {
+			s.times = &extremeTimes{min: at, max: at}
+		}
+		if ht.histograms {
+			s.hist = &histogram{}
+			s.hist.add(temp, 1)
+		}
+		ht.add(hash, key, s)
+		return
+	}
+	if ht.histograms {
+		if s.hist == nil {
+			// A seeded station's first row
+			s.hist = &histogram{}
+		}
+		s.hist.add(temp, 1)
+	}
+	if text != nil && s.extremes == nil {
+		// A seeded station's first row
+		s.extremes = &extremeText{}
+	}
+	if ht.times {
+		s.recordTimes(at, temp < s.min, temp > s.max)
+	}
+	if temp < s.min {
+		s.min = temp
+		if text != nil {
+			s.extremes.min = bytes.Clone(text)
+		}
+	}
+	if temp > s.max {
+		s.max = temp
+		if text != nil {
+			s.extremes.max = bytes.Clone(text)
+		}
+	}
+	s.addSum(int64(temp))
+	s.count++
+	if offset < s.first {
+		s.first = offset
+	}
+	if offset > s.last {
+		s.last = offset
+	}
+}
+
+// shiftOffsets adds delta to every recorded offset, for tables filled from
+// a window of the input rather than the whole of it.
+func (ht *hashtable) shiftOffsets(delta int64) {
+	for _, item := range ht.items {
+		if item.value != nil {
+			item.value.first += delta
+			item.value.last += delta
+		}
+	}
+}
+
+// Merge folds the stats of another hashtable into ht.
+func (ht *hashtable) Merge(other Accumulator) {
+	for _, item := range other.(*hashtable).items {
+		if item.value == nil {
+			continue
+		}
+
+		s := ht.get(item.hash, item.key)
+		if s == nil {
+			v := *item.value
+			ht.add(item.hash, item.key, &v)
+		} else {
+			s.merge(item.value)
+		}
+	}
+}
+
+// Stats is one station's partial result as exchanged with MergeStream.
+// Temperatures are in tenths of a degree Celsius. MinText and MaxText are
+// the extremes as written, for -keep-extremes, and empty otherwise. A
+// station with no rows, such as a seeded one, has a zero Count.
+type Stats struct {
+	Min, Max         int32
+	Sum              int64
+	Count            uint64
+	MinText, MaxText string
+}
+
+// partialStats returns the stations of ht in the form MergeStream takes.
+// The names and texts are copied, so the result outlives the input.
+func partialStats(ht *hashtable) map[string]Stats {
+	res := make(map[string]Stats, ht.size)
+	for _, item := range ht.items {
+		if item.value == nil {
+			continue
+		}
+		s := item.value
+		p := Stats{Min: s.min, Max: s.max, Sum: s.sum, Count: s.count}
+		if s.extremes != nil {
+			p.MinText, p.MaxText = string(s.extremes.min), string(s.extremes.max)
+		}
+		res[string(item.key)] = p
+	}
+	return res
+}
+
+// MergeStream folds each partial result received on ch into a running
+// total as it arrives, until ch is closed, and returns the total. This lets
+// a pipeline reduce partial results while later ones are still being
+// produced, rather than only once all of them are done.
+//
+// The total doesn't depend on the order the partials arrive in: Stats are
+// values, so nothing is shared with a partial once it's read, and a tie
+// between extremes written differently, such as 1.0 and 1.00, keeps the
+// text that sorts first rather than the one that arrived first.
+func MergeStream(ch <-chan map[string]Stats) map[string]Stats {
+	res := make(map[string]Stats)
+	for partial := range ch {
+		for name, s := range partial {
+			cur, ok := res[name]
+			switch {
+			case !ok || cur.Count == 0:
+				res[name] = s
+				continue
+			case s.Count == 0:
+				continue
+			}
+			if s.Min < cur.Min || s.Min == cur.Min && s.MinText < cur.MinText {
+				cur.Min, cur.MinText = s.Min, s.MinText
+			}
+			if s.Max > cur.Max || s.Max == cur.Max && s.MaxText < cur.MaxText {
+				cur.Max, cur.MaxText = s.Max, s.MaxText
+			}
+			cur.Sum += s.Sum
+			cur.Count += s.Count
+			res[name] = cur
+		}
+	}
+	return res
+}
+
+// mergeAccumulators folds every worker's accumulator into res.
+func mergeAccumulators(results []*chunkResult, res Accumulator) Accumulator {
+	for _, r := range results {
+		res.Merge(r.acc)
+	}
+	return res
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// rowCounter is a minimal custom Accumulator that only counts rows.
+type rowCounter struct{ rows int }
+
+func (c *rowCounter) Update(key []byte, hash uint64, temp int32) { c.rows++ }
+func (c *rowCounter) Merge(other Accumulator)                    { c.rows += other.(*rowCounter).rows }
+
+func (c *rowCounter) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "rows=%d\n", c.rows)
+	return int64(n), err
+}
+
+func TestProcessCustomAccumulator(t *testing.T) {
+	opts := options{
+		workers: 3,
+		accumulator: func(uint64) Accumulator {
+			return &rowCounter{}
+		},
+	}
+	got := runProcess(t, "a;1.0\nb;2.0\na;3.0\nc;4.0\n", opts)
+	if got != "rows=4\n" {
+		t.Errorf("got %q, want %q", got, "rows=4\n")
+	}
+}
+
+func TestProcessOffsets(t *testing.T) {
+	// Pad the input over several pages so later windows start at non-zero
+	// offsets. Each row is 6 bytes, so the rows after the padding start at
+	// 18000, 18006, ...
+	contents := strings.Repeat("z;0.0\n", 3000) + "a;1.0\nb;2.0\na;3.0\nc;4.0\nb;5.0\n"
+	want := "a=18000/18012\nb=18006/18024\nc=18018/18018\nz=0/17994\n"
+	configs := map[string]options{
+		"one worker":   {workers: 1},
+		"workers":      {workers: 3},
+		"low-mem":      {workers: 3, lowMem: true},
+		"windowed":     {workers: 2, windowSize: 4096},
+		"auto workers": {workers: 2, autoWorkers: true},
+	}
+	for name, opts := range configs {
+		opts.offsets = true
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWideSum(t *testing.T) {
+	for _, sign := range []int64{1, -1} {
+		// Each half is well within int64, but their sum is not
+		half := stats{min: -999, max: 999, sum: sign * 6e18, count: 1e15}
+		s, o := half, half
+		s.merge(&o)
+		if s.sumHi == 0 {
+			t.Fatalf("sign %d: merge did not carry: %+v", sign, s)
+		}
+		if got, want := s.meanTenths(), sign*6000; got != want {
+			t.Errorf("sign %d: mean got %d, want %d", sign, got, want)
+		}
+
+		// Row by row past the int64 limit, then back below it
+		ht := NewHashTable(8)
+		key := []byte("a")
+		hash := hashBytes(key, 0, len(key))
+		ht.add(hash, key, &stats{min: -999, max: 999, sum: sign * (math.MaxInt64 - 1), count: 1e16})
+		for i := 0; i < 3; i++ {
+			ht.UpdateRow(key, hash, int32(sign*999), 0, nil, noTime)
+		}
+		got := *ht.get(hash, key)
+		if got.sumHi != sign {
+			t.Fatalf("sign %d: UpdateRow did not carry: %+v", sign, got)
+		}
+		// (2^63 + 2996) / (1e16 + 3) tenths is about 922.3
+		if mean, want := got.meanTenths(), sign*922; mean != want {
+			t.Errorf("sign %d: row mean got %d, want %d", sign, mean, want)
+		}
+		ht.UpdateRow(key, hash, int32(-sign*999), 0, nil, noTime)
+		if got := ht.get(hash, key); got.sumHi != sign || got.sum != sign*(math.MaxInt64-1)+sign*1998 {
+			t.Errorf("sign %d: %+v after a row back", sign, got)
+		}
+	}
+
+	// sumHi survives the -low-mem run records
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeRecords(w, []item{{key: []byte("a"), value: &stats{sum: -5, sumHi: 7, count: 3}}})
+	w.Flush()
+	rr := &runReader{r: bufio.NewReader(&buf)}
+	if ok, err := rr.next(); !ok || err != nil || rr.stats.sumHi != 7 || rr.stats.sum != -5 {
+		t.Errorf("run record round trip: %v %v %+v", ok, err, rr.stats)
+	}
+
+	contents := "a;1.0\nb;-2.5\na;3.0\n"
+	if got, want := runProcess(t, contents, options{workers: 2, wideSum: true}), runProcess(t, contents, options{workers: 2}); got != want {
+		t.Errorf("-wide-sum changed the output: got %q, want %q", got, want)
+	}
+}
+
+func TestProcessKeepExtremes(t *testing.T) {
+	// -0.0 sets the min and the later 0.0 only ties it; 9.5 and the later
+	// 09.5 tie for the max under -parse-mode strict
+	contents := "a;-0.0\nb;1.0\na;9.5\na;0.0\nb;-2.0\na;09.5\nb;1.0\n"
+	want := "{a=0.0/4.8/9.5 (-0.0/9.5), b=-2.0/0.0/1.0 (-2.0/1.0)}\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 7},
+		{workers: 2, lowMem: true},
+		{workers: 2, windowSize: 4096},
+		{workers: 3, offsets: true},
+	} {
+		opts.keepExtremes = true
+		opts.generalParse = true
+		if opts.offsets {
+			// -offsets takes over the output; the extremes must still be
+			// tracked alongside the offsets without disturbing them
+			if got, want := runProcess(t, contents, opts), "a=0/32\nb=7/39\n"; got != want {
+				t.Errorf("%+v: got %q, want %q", opts, got, want)
+			}
+			continue
+		}
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	got := runProcess(t, contents, options{workers: 2, keepExtremes: true, generalParse: true, format: "ndjson"})
+	wantJSON := `{"station":"a","min":0.0,"mean":4.8,"max":9.5,"count":4,"min_text":"-0.0","max_text":"9.5"}` + "\n" +
+		`{"station":"b","min":-2.0,"mean":0.0,"max":1.0,"count":3,"min_text":"-2.0","max_text":"1.0"}` + "\n"
+	if got != wantJSON {
+		t.Errorf("ndjson: got %q, want %q", got, wantJSON)
+	}
+}
+
+func TestMergeStream(t *testing.T) {
+	contents := "a;1.0\nb;-2.0\na;3.0\nc;4.5\nb;6.0\na;-9.9\nc;0.5\nd;7.0\nb;6.00\n"
+	data := []byte(contents)
+	opts := &options{keepExtremes: true}
+	whole := processBlock(data, 0, len(data), opts, NewHashTable(1<<4)).acc.(*hashtable)
+
+	blocks := splitBlocks(data, 0, len(data), 4, '\n')
+	partials := make([]map[string]Stats, len(blocks))
+	for i, b := range blocks {
+		partials[i] = partialStats(processBlock(data, b.start, b.end, opts, NewHashTable(1<<4)).acc.(*hashtable))
+	}
+	// merge sends the partials in order, and a seeded station without rows
+	merge := func(order []int) map[string]Stats {
+		ch := make(chan map[string]Stats)
+		go func() {
+			ch <- map[string]Stats{"e": {}}
+			for _, i := range order {
+				ch <- partials[i]
+			}
+			close(ch)
+		}()
+		return MergeStream(ch)
+	}
+
+	want := partialStats(whole)
+	want["e"] = Stats{}
+	// b's max is tied between 6.0 and 6.00, which sorts first whatever
+	// order they arrive in
+	want["b"] = Stats{Min: -20, Max: 60, Sum: 100, Count: 3, MinText: "-2.0", MaxText: "6.0"}
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}} {
+		got := merge(order)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("order %v: got %v, want %v", order, got, want)
+		}
+	}
+
+	// The total owns its values, so changing a partial afterwards doesn't
+	// change it
+	got := merge([]int{0, 1, 2, 3})
+	for _, p := range partials {
+		for name := range p {
+			p[name] = Stats{Count: 99}
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after changing the partials: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// stationLimit is the -max-stations guard against a broken upstream
+// emitting garbage names. Each worker's table trips it as soon as it holds
+// more than max stations of its own, which is proof enough that the merged
+// result would, and every worker then stops at its next line, so the run
+// fails fast rather than filling and growing tables with millions of
+// entries. Stations spread thin across the workers only show up once the
+// tables are merged, which check catches.
+type stationLimit struct {
+	max      int
+	exceeded atomic.Bool
+}
+
+// watch has acc, a worker's accumulator, trip l once it holds more than
+// l's stations on top of any it was seeded with, which don't count until
+// they have rows. Only the default hashtable can be watched; the others
+// are left to check.
+func (l *stationLimit) watch(acc Accumulator) {
+	ht, ok := acc.(*hashtable)
+	if l == nil || !ok {
+		return
+	}
+	ht.limit, ht.limitSize = l, uint64(l.max)+ht.size
+}
+
+// hit reports whether a worker's table has passed the limit, so the
+// workers can stop. A nil limit is never hit.
+func (l *stationLimit) hit() bool {
+	return l != nil && l.exceeded.Load()
+}
+
+// err returns the error for a worker's table having passed the limit, if
+// one did.
+func (l *stationLimit) err() error {
+	if !l.hit() {
+		return nil
+	}
+	return fmt.Errorf("more than %d distinct stations (-max-stations)", l.max)
+}
+
+// check returns an error if res, a merged table, holds more than the
+// limit's stations with rows. Seeded stations without rows don't count.
+func (l *stationLimit) check(res *hashtable) error {
+	if l == nil || res.size <= uint64(l.max) {
+		return nil
+	}
+	n := 0
+	for _, item := range res.items {
+		if item.value != nil && item.value.count > 0 {
+			n++
+		}
+	}
+	if n > l.max {
+		return fmt.Errorf("%d distinct stations, more than -max-stations %d", n, l.max)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProcessMaxStations(t *testing.T) {
+	contents := "a;1.0\nb;2.0\na;3.0\nc;4.0\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 2},
+		{workers: 2, windowSize: 12},
+		{workers: 2, fixed: &fixedLayout{station: 1, temp: 4}},
+	} {
+		input := contents
+		if opts.fixed != nil {
+			input = strings.ReplaceAll(contents, ";", "")
+		}
+		opts.maxStations = &stationLimit{max: 3}
+		if got, want := runProcess(t, input, opts), "{a=1.0/2.0/3.0, b=2.0/2.0/2.0, c=4.0/4.0/4.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+
+		opts.maxStations = &stationLimit{max: 2}
+		if err := process(io.Discard, writeTempFile(t, input), opts); err == nil || !strings.Contains(err.Error(), "-max-stations") {
+			t.Errorf("%+v: got %v, want a -max-stations error", opts, err)
+		}
+	}
+
+	// Split a;b and c;d across two workers, so neither passes the limit of
+	// 3 on its own and only the merged table does
+	opts := options{workers: 2, maxStations: &stationLimit{max: 3}}
+	err := process(io.Discard, writeTempFile(t, "a;1.0\nb;1.0\nc;1.0\nd;1.0\n"), opts)
+	if want := "4 distinct stations, more than -max-stations 3"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	if opts.maxStations.hit() {
+		t.Error("a worker tripped the limit")
+	}
+
+	// Seeds without rows don't count towards the limit
+	opts = options{workers: 1, maxStations: &stationLimit{max: 3}, seeds: [][]byte{[]byte("x"), []byte("y")}}
+	if got, want := runProcess(t, contents, opts), "{a=1.0/2.0/3.0, b=2.0/2.0/2.0, c=4.0/4.0/4.0}\n"; got != want {
+		t.Errorf("seeded: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// checkResult is one worker's tally for -check.
+type checkResult struct {
+	valid     int
+	malformed int
+
+	// clamped counts the valid lines whose temperature is outside -clamp,
+	// which a run would move to a bound; under -strict they're malformed
+	clamped int
+
+	// firstMalformed is the byte offset of the first malformed line, or -1
+	firstMalformed int
+}
+
+// checkFile validates every line of data from start in parallel, without
+// building any tables, and writes a summary to output. It returns an error
+// if any line is malformed.
+func checkFile(output io.Writer, data []byte, start int, opts *options) error {
+	return reportCheck(output, checkRange(data, start, len(data), opts))
+}
+
+// checkRange validates the lines of data[start:end] in parallel.
+func checkRange(data []byte, start, end int, opts *options) checkResult {
+	blocks := splitBlocks(data, start, end, opts.workers, opts.eol())
+	results := make([]checkResult, len(blocks))
+
+	var wg sync.WaitGroup
+	wg.Add(len(blocks))
+	for i, blk := range blocks {
+		go func(i int, blk block) {
+			defer wg.Done()
+			results[i] = checkData(data, blk.start, blk.end, opts)
+		}(i, blk)
+	}
+	wg.Wait()
+
+	total := checkResult{firstMalformed: -1}
+	for _, r := range results {
+		total.add(r, 0)
+	}
+	return total
+}
+
+// add folds a later range's tally into c, shifting its offset by base.
+func (c *checkResult) add(r checkResult, base int) {
+	c.valid += r.valid
+	c.malformed += r.malformed
+	c.clamped += r.clamped
+	if c.firstMalformed < 0 && r.firstMalformed >= 0 {
+		c.firstMalformed = base + r.firstMalformed
+	}
+}
+
+// reportCheck writes the -check summary and returns an error if any line
+// was malformed.
+func reportCheck(output io.Writer, total checkResult) error {
+	fmt.Fprintf(output, "%d valid lines, %d malformed lines\n", total.valid, total.malformed)
+	if total.clamped > 0 {
+		fmt.Fprintf(output, "%d temperatures outside -clamp\n", total.clamped)
+	}
+	if total.malformed == 0 {
+		return nil
+	}
+	fmt.Fprintf(output, "first malformed line at byte %d\n", total.firstMalformed)
+	return fmt.Errorf("%d malformed lines", total.malformed)
+}
+
+// checkData applies the same validation as strict mode to each line of
+// data[start:endPos], through the fieldParser processData uses. A line of
+// an -exclude'd station counts as valid, as the run drops it unchecked.
+func checkData(data []byte, start int, endPos int, opts *options) checkResult {
+	res := checkResult{firstMalformed: -1}
+	parser := newFieldParser(opts, true)
+	var rec record
+	eol := opts.eol()
+
+	i := start
+	for i < endPos {
+		lineStart := i
+		if opts.comment != 0 && data[i] == opts.comment {
+			i = nextLine(data, i, endPos, eol)
+			continue
+		}
+
+		semicolonPos := i
+		delimLen := 1
+		if opts.delimiter != nil {
+			delimLen = len(opts.delimiter)
+			end := nextLine(data, i, endPos, eol) - 1
+			semicolonPos = end
+			if idx := bytes.Index(data[i:end], opts.delimiter); idx >= 0 {
+				semicolonPos = i + idx
+			}
+		} else {
+			for ; semicolonPos < endPos && data[semicolonPos] != ';' && data[semicolonPos] != eol; semicolonPos++ {
+			}
+		}
+		lineEnd := semicolonPos
+		for ; lineEnd < endPos && data[lineEnd] != eol; lineEnd++ {
+		}
+		i = lineEnd + 1
+
+		ok := semicolonPos < lineEnd
+		if ok {
+			afterDelim := semicolonPos + delimLen
+			if opts.squeezeDelim {
+				afterDelim = skipDelims(data, afterDelim, lineEnd, opts.delimiter)
+			}
+			station, value := data[lineStart:semicolonPos], data[afterDelim:lineEnd]
+			if opts.reverseFields {
+				station, value = value, station
+			}
+			verdict, _ := parser.parse(station, value, &rec)
+			ok = verdict != recordMalformed
+			if verdict == recordAccepted && rec.clamped {
+				res.clamped++
+			}
+		}
+		if ok {
+			res.valid++
+			continue
+		}
+
+		res.malformed++
+		if res.firstMalformed < 0 {
+			res.firstMalformed = lineStart
+		}
+	}
+	return res
+}
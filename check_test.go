@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestProcessCheck(t *testing.T) {
+	contents := "Abha;12.3\nBeirut;-4.5\nno delimiter\nCairo;1.x\nAbha;1.0"
+	for _, workers := range []int{1, 4} {
+		var out bytes.Buffer
+		err := process(&out, writeTempFile(t, contents), options{workers: workers, check: true})
+		if err == nil {
+			t.Fatalf("workers=%d: expected an error", workers)
+		}
+		want := "3 valid lines, 2 malformed lines\nfirst malformed line at byte 22\n"
+		if out.String() != want {
+			t.Errorf("workers=%d: got %q, want %q", workers, out.String(), want)
+		}
+	}
+
+	got := runProcess(t, "Abha;12.3\nBeirut;-4.5\n", options{workers: 2, check: true})
+	if got != "2 valid lines, 0 malformed lines\n" {
+		t.Errorf("clean input: got %q", got)
+	}
+}
+
+// TestCheckAgreesWithStrict runs -check and a -strict run over the same
+// lines under each parsing flag and expects them to find the same number
+// of malformed lines.
+func TestCheckAgreesWithStrict(t *testing.T) {
+	contents := "a;12.3\nb;-4.5\nc;1.25\nd;1.2e1\ne;123\nf;12.3C\ng;55.1F\n" +
+		"h;1.0;1700000000\ni  j;2.0\nk ;3.0\n;4.0\nl;\nm;99.9\n#x;1.0\nn;;5.0\no|6.0\np;-\n"
+	c, err := parseClamp("-50,50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	excluded := newStationSet([][]byte{[]byte("d"), []byte("l")})
+	for _, opts := range []options{
+		{},
+		{generalParse: true},
+		{sciNotation: true, generalParse: true},
+		{tenths: true},
+		{parseUnit: true},
+		{withTime: true, epochTime: true},
+		{withTime: true},
+		{clamp: &c},
+		{trimKeys: true},
+		{collapseSpace: true},
+		{groupPrefix: 1},
+		{excluded: excluded},
+		{delimiter: []byte("|")},
+		{squeezeDelim: true},
+		{reverseFields: true},
+		{comment: '#'},
+		{generalParse: true, parseUnit: true, clamp: &c, comment: '#', squeezeDelim: true},
+	} {
+		path := writeTempFile(t, contents)
+		opts.workers = 2
+		opts.strict = true
+		strictErr := process(io.Discard, path, opts)
+
+		opts.check = true
+		var out bytes.Buffer
+		checkErr := process(&out, path, opts)
+		if fmt.Sprint(checkErr) != fmt.Sprint(strictErr) {
+			t.Errorf("%+v: -check got %v (%q), -strict got %v", opts, checkErr, out.String(), strictErr)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkpointMagic starts every checkpoint file
+const checkpointMagic = "1BRCCKP2"
+
+// checkpointHeaderLen is the size of a checkpoint before its records: the
+// magic, the input's size and the offset processing resumes from.
+const checkpointHeaderLen = len(checkpointMagic) + 8 + 8
+
+// writeCheckpoint saves the stations in ht, which cover the input of size
+// bytes up to offset, to fileName. The checkpoint is written alongside and
+// renamed over fileName so a crash mid-write leaves the previous one whole.
+//
+// The body is the -low-mem run record format, one record per station.
+func writeCheckpoint(fileName string, size, offset int64, ht *hashtable) error {
+	tmp := fileName + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	var header [checkpointHeaderLen]byte
+	copy(header[:], checkpointMagic)
+	binary.LittleEndian.PutUint64(header[len(checkpointMagic):], uint64(size))
+	binary.LittleEndian.PutUint64(header[len(checkpointMagic)+8:], uint64(offset))
+	w.Write(header[:])
+	writeRecords(w, populatedItems(ht))
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// loadCheckpoint reads a checkpoint written by writeCheckpoint into ht and
+// returns the offset to resume from. The checkpoint must have been taken
+// over an input of the same size.
+func loadCheckpoint(fileName string, size int64, ht *hashtable) (int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var header [checkpointHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || !bytes.HasPrefix(header[:], []byte(checkpointMagic)) {
+		return 0, fmt.Errorf("%s is not a checkpoint", fileName)
+	}
+	if got := int64(binary.LittleEndian.Uint64(header[len(checkpointMagic):])); got != size {
+		return 0, fmt.Errorf("checkpoint %s is of a %d byte input, not %d bytes", fileName, got, size)
+	}
+	offset := int64(binary.LittleEndian.Uint64(header[len(checkpointMagic)+8:]))
+
+	rr := &runReader{r: r}
+	for {
+		ok, err := rr.next()
+		if err != nil {
+			return 0, fmt.Errorf("reading checkpoint %s: %w", fileName, err)
+		}
+		if !ok {
+			return offset, nil
+		}
+		key := bytes.Clone(rr.key)
+		s := rr.stats
+		ht.add(hashBytes(key, 0, len(key)), key, &s)
+	}
+}
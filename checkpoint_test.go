@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessCheckpointResume(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%23, i%50-25, i%10)
+	}
+	contents := buf.String()
+	path := writeTempFile(t, contents)
+	want := runProcess(t, contents, options{workers: 2})
+
+	// A checkpoint taken every window ends up covering the whole input
+	ckpt := filepath.Join(t.TempDir(), "run.ckpt")
+	var out bytes.Buffer
+	if err := process(&out, path, options{workers: 2, windowSize: 4096, checkpoint: ckpt}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("checkpointed: got %.200q, want %.200q", out.String(), want)
+	}
+	out.Reset()
+	if err := process(&out, path, options{workers: 2, resume: ckpt}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("resumed at end: got %.200q, want %.200q", out.String(), want)
+	}
+
+	// Resume from a checkpoint partway through, not on a page boundary
+	prefix := contents[:strings.IndexByte(contents[5000:], '\n')+5001]
+	partial := processData([]byte(prefix), 0, len(prefix), &options{}, NewHashTable(1<<10))
+	if err := writeCheckpoint(ckpt, int64(len(contents)), int64(len(prefix)), partial.acc.(*hashtable)); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := process(&out, path, options{workers: 3, windowSize: 4096, resume: ckpt}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("resumed partway: got %.200q, want %.200q", out.String(), want)
+	}
+
+	if err := process(io.Discard, writeTempFile(t, contents[:100]), options{workers: 1, resume: ckpt}); err == nil {
+		t.Error("resumed from a checkpoint of a different size")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// clampRange is the range -clamp holds temperatures to, in tenths of a
+// degree of the input's unit.
+type clampRange struct {
+	lo, hi int32
+}
+
+// parseClamp parses -clamp's min,max in degrees.
+func parseClamp(s string) (clampRange, error) {
+	loText, hiText, ok := strings.Cut(s, ",")
+	if !ok {
+		return clampRange{}, fmt.Errorf("-clamp must be min,max, got %q", s)
+	}
+	lo, loOK := parseTempGeneral([]byte(strings.TrimSpace(loText)))
+	hi, hiOK := parseTempGeneral([]byte(strings.TrimSpace(hiText)))
+	if !loOK || !hiOK {
+		return clampRange{}, fmt.Errorf("-clamp must be min,max in degrees, got %q", s)
+	}
+	if lo > hi {
+		return clampRange{}, fmt.Errorf("-clamp's min %s is above its max %s", loText, hiText)
+	}
+	return clampRange{lo, hi}, nil
+}
+
+// contains reports whether temp is within the range.
+func (c *clampRange) contains(temp int32) bool {
+	return temp >= c.lo && temp <= c.hi
+}
+
+// apply returns temp moved to the nearest bound if it's outside the range.
+func (c *clampRange) apply(temp int32) int32 {
+	if temp < c.lo {
+		return c.lo
+	}
+	if temp > c.hi {
+		return c.hi
+	}
+	return temp
+}
+
+// totalClamped adds up the clamped temperatures of all workers.
+func totalClamped(results []*chunkResult) int {
+	total := 0
+	for _, r := range results {
+		total += r.clamped
+	}
+	return total
+}
+
+// reportClamped writes how many temperatures -clamp moved to w.
+func reportClamped(w io.Writer, clamped int, c *clampRange) {
+	if w == nil || c == nil {
+		return
+	}
+	fmt.Fprintf(w, "clamped %d temperatures to [%s, %s]\n", clamped, appendTenths(nil, int64(c.lo)), appendTenths(nil, int64(c.hi)))
+}
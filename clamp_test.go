@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProcessClamp(t *testing.T) {
+	contents := "a;-60.0\nb;20.0\na;10.0\nb;75.5\n"
+	c, err := parseClamp("-50,50.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeTempFile(t, contents)
+	tests := []struct {
+		opts options
+		want string
+		diag string
+	}{
+		{options{workers: 2}, "{a=-50.0/-20.0/10.0, b=20.0/35.0/50.0}\n", ""},
+		{options{workers: 2, stats: true}, "{a=-50.0/-20.0/10.0, b=20.0/35.0/50.0}\n", "clamped 2 temperatures to [-50.0, 50.0]\n"},
+		{options{workers: 2, stats: true, windowSize: 16}, "{a=-50.0/-20.0/10.0, b=20.0/35.0/50.0}\n", "clamped 2 temperatures to [-50.0, 50.0]\n"},
+		{options{workers: 1, stats: true, fixed: &fixedLayout{station: 2, temp: 5}}, "{a=-50.0/-50.0/-50.0}\n", "clamped 1 temperatures to [-50.0, 50.0]\n"},
+		{options{workers: 2, check: true}, "4 valid lines, 0 malformed lines\n2 temperatures outside -clamp\n", ""},
+		{options{workers: 2, check: true, windowSize: 16}, "4 valid lines, 0 malformed lines\n2 temperatures outside -clamp\n", ""},
+		{options{workers: 2, check: true, strict: true}, "2 valid lines, 2 malformed lines\nfirst malformed line at byte 0\n", ""},
+	}
+	for _, tt := range tests {
+		var out, diag bytes.Buffer
+		tt.opts.clamp = &c
+		tt.opts.diag = &diag
+		path := path
+		if tt.opts.fixed != nil {
+			path = writeTempFile(t, "a -60.0\n")
+		}
+		err := process(&out, path, tt.opts)
+		if err != nil && !tt.opts.check {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want || !strings.HasSuffix(diag.String(), tt.diag) {
+			t.Errorf("%+v: got %q and diag %q, want %q and %q", tt.opts, out.String(), diag.String(), tt.want, tt.diag)
+		}
+	}
+
+	// Under -strict the lines outside are rejected
+	var out, diag bytes.Buffer
+	err = process(&out, path, options{workers: 2, strict: true, clamp: &c, diag: &diag})
+	if err == nil || !strings.Contains(diag.String(), "a;-60.0") || !strings.Contains(diag.String(), "b;75.5") {
+		t.Errorf("strict: got error %v and diag %q", err, diag.String())
+	}
+
+	for _, bad := range []string{"50", "a,b", "10,-10"} {
+		if _, err := parseClamp(bad); err == nil {
+			t.Errorf("parseClamp(%q) succeeded", bad)
+		}
+	}
+}
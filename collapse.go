@@ -0,0 +1,38 @@
+package main
+
+import "bytes"
+
+// spaceCollapser implements -collapse-space for one worker. Collapsed keys
+// no longer alias the input, so each distinct one is copied once into an
+// owned buffer and reused for every later row with the same key.
+type spaceCollapser struct {
+	scratch []byte
+	owned   map[string][]byte
+}
+
+func newSpaceCollapser() *spaceCollapser {
+	return &spaceCollapser{owned: make(map[string][]byte)}
+}
+
+// collapse returns key with every run of spaces replaced by a single space.
+// Keys without a run are returned as is.
+func (c *spaceCollapser) collapse(key []byte) []byte {
+	if !bytes.Contains(key, []byte("  ")) {
+		return key
+	}
+
+	c.scratch = c.scratch[:0]
+	for i, b := range key {
+		if b == ' ' && i > 0 && key[i-1] == ' ' {
+			continue
+		}
+		c.scratch = append(c.scratch, b)
+	}
+
+	if owned, ok := c.owned[string(c.scratch)]; ok {
+		return owned
+	}
+	owned := bytes.Clone(c.scratch)
+	c.owned[string(owned)] = owned
+	return owned
+}
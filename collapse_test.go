@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessCollapseSpace(t *testing.T) {
+	// "Las   Vegas " folds into "Las Vegas " but only -trim-keys also folds
+	// it into "Las Vegas"; "LasVegas" and "Las Vega s" must stay distinct
+	contents := "Las Vegas;1.0\nLas  Vegas;3.0\nLas   Vegas ;5.0\nLasVegas;7.0\nLas Vega s;9.0\nLas  Vegas;-1.0\n"
+
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{collapseSpace: true}, "{Las Vega s=9.0/9.0/9.0, Las Vegas=-1.0/1.0/3.0, Las Vegas =5.0/5.0/5.0, LasVegas=7.0/7.0/7.0}\n"},
+		{options{collapseSpace: true, trimKeys: true}, "{Las Vega s=9.0/9.0/9.0, Las Vegas=-1.0/2.0/5.0, LasVegas=7.0/7.0/7.0}\n"},
+		{options{collapseSpace: true, lowMem: true}, "{Las Vega s=9.0/9.0/9.0, Las Vegas=-1.0/1.0/3.0, Las Vegas =5.0/5.0/5.0, LasVegas=7.0/7.0/7.0}\n"},
+	}
+	for _, tt := range tests {
+		for _, workers := range []int{1, 3} {
+			tt.opts.workers = workers
+			if got := runProcess(t, contents, tt.opts); got != tt.want {
+				t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+			}
+		}
+	}
+
+	fixed := "Las  Vegas  1.0\nLas Vegas   3.0\nLasVegas    5.0\n"
+	got := runProcess(t, fixed, options{workers: 2, collapseSpace: true, fixed: &fixedLayout{station: 12, temp: 4}})
+	if want := "{Las Vegas=1.0/2.0/3.0, LasVegas=5.0/5.0/5.0}\n"; got != want {
+		t.Errorf("fixed: got %q, want %q", got, want)
+	}
+}
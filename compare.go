@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// compareFiles implements -compare: it aggregates the files a and b with
+// opts and writes, for every station in either, in name order, how b's
+// min, mean, max and count differ from a's, as in
+//
+//	Abha: min +0.5, mean -0.1, max 0.0, count +3
+//	Tokyo: only in b.txt
+//
+// Stations kept by -keep-empty without rows have NA temperatures.
+func compareFiles(output io.Writer, a, b string, opts options) error {
+	aItems, err := aggregateItems(a, opts)
+	if err != nil {
+		return err
+	}
+	bItems, err := aggregateItems(b, opts)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(output)
+	var buf []byte
+	i, j := 0, 0
+	for i < len(aItems) || j < len(bItems) {
+		var cmp int
+		switch {
+		case i == len(aItems):
+			cmp = 1
+		case j == len(bItems):
+			cmp = -1
+		default:
+			cmp = bytes.Compare(aItems[i].key, bItems[j].key)
+		}
+
+		switch {
+		case cmp < 0:
+			buf = appendOnlyIn(buf[:0], aItems[i].key, a)
+			i++
+		case cmp > 0:
+			buf = appendOnlyIn(buf[:0], bItems[j].key, b)
+			j++
+		default:
+			buf = appendDelta(buf[:0], aItems[i].key, aItems[i].value, bItems[j].value)
+			i++
+			j++
+		}
+		w.Write(buf)
+	}
+	return w.Flush()
+}
+
+func appendOnlyIn(dst, key []byte, fileName string) []byte {
+	dst = append(dst, key...)
+	dst = append(dst, ": only in "...)
+	dst = append(dst, fileName...)
+	return append(dst, '\n')
+}
+
+// appendDelta appends the line for a station in both files, with each
+// difference signed.
+func appendDelta(dst, key []byte, a, b *stats) []byte {
+	dst = append(dst, key...)
+	if a.count == 0 || b.count == 0 {
+		dst = append(dst, ": min NA, mean NA, max NA"...)
+	} else {
+		dst = append(dst, ": min "...)
+		dst = appendSignedTenths(dst, int64(b.min)-int64(a.min))
+		dst = append(dst, ", mean "...)
+		dst = appendSignedTenths(dst, b.meanTenths()-a.meanTenths())
+		dst = append(dst, ", max "...)
+		dst = appendSignedTenths(dst, int64(b.max)-int64(a.max))
+	}
+	dst = append(dst, ", count "...)
+	switch {
+	case b.count > a.count:
+		dst = append(dst, '+')
+		dst = strconv.AppendUint(dst, b.count-a.count, 10)
+	case b.count < a.count:
+		dst = append(dst, '-')
+		dst = strconv.AppendUint(dst, a.count-b.count, 10)
+	default:
+		dst = append(dst, '0')
+	}
+	return append(dst, '\n')
+}
+
+// appendSignedTenths is appendTenths with a + on positive differences.
+func appendSignedTenths(dst []byte, v int64) []byte {
+	if v > 0 {
+		dst = append(dst, '+')
+	}
+	return appendTenths(dst, v)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(a, []byte("Abha;1.0\nAbha;3.0\nBonn;-2.0\nCairo;20.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("Abha;1.5\nAbha;2.0\nAbha;2.5\nCairo;20.0\nDakar;30.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := compareFiles(&out, a, b, options{workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := "Abha: min +0.5, mean 0.0, max -0.5, count +1\n" +
+		"Bonn: only in " + a + "\n" +
+		"Cairo: min 0.0, mean 0.0, max 0.0, count 0\n" +
+		"Dakar: only in " + b + "\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// -samples has no stats to compare, and used to report none
+	samples := options{workers: 2, accumulator: func(uint64) Accumulator { return newSampleAccumulator(2) }}
+	if err := compareFiles(io.Discard, a, b, samples); err == nil {
+		t.Error("samples: got no error")
+	}
+}
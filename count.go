@@ -0,0 +1,42 @@
+package main
+
+// countByteGeneric is the portable implementation of countByte.
+func countByteGeneric(data []byte, start, end int, target byte) int {
+	n := 0
+	for _, c := range data[start:end] {
+		if c == target {
+			n++
+		}
+	}
+	return n
+}
+
+// countRows returns the number of lines in data, which end with eol,
+// counting a final line without one. The count is split across workers.
+func countRows(data []byte, numWorkers int, eol byte) int {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	chunkSize := len(data) / numWorkers
+
+	counts := make(chan int, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if i == numWorkers-1 {
+			end = len(data)
+		}
+		go func() {
+			counts <- countByte(data, start, end, eol)
+		}()
+	}
+
+	rows := 0
+	for i := 0; i < numWorkers; i++ {
+		rows += <-counts
+	}
+	if len(data) > 0 && data[len(data)-1] != eol {
+		rows++
+	}
+	return rows
+}
@@ -0,0 +1,53 @@
+//go:build amd64 && !nosimd
+
+package main
+
+// hasAVX2 reports whether the CPU and OS support AVX2.
+var hasAVX2 = detectAVX2()
+
+func detectAVX2() bool {
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 7 {
+		return false
+	}
+
+	// The OS must have enabled XSAVE and the YMM register state
+	_, _, ecx1, _ := cpuid(1, 0)
+	const osxsave = 1 << 27
+	if ecx1&osxsave == 0 {
+		return false
+	}
+	xcr0, _ := xgetbv()
+	if xcr0&0x6 != 0x6 {
+		return false
+	}
+
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx7&avx2 != 0
+}
+
+//go:noescape
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+//go:noescape
+func xgetbv() (eax, edx uint32)
+
+// countByteAVX2 counts target in data, which must be a multiple of 32 bytes
+// long, comparing 32 bytes at a time and popcounting the match mask.
+//
+//go:noescape
+func countByteAVX2(data []byte, target byte) int
+
+// countByte returns how many times target appears in data[start:end].
+func countByte(data []byte, start, end int, target byte) int {
+	if !hasAVX2 {
+		return countByteGeneric(data, start, end, target)
+	}
+	n := (end - start) &^ 31
+	count := 0
+	if n > 0 {
+		count = countByteAVX2(data[start:start+n], target)
+	}
+	return count + countByteGeneric(data, start+n, end, target)
+}
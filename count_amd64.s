@@ -0,0 +1,50 @@
+//go:build amd64 && !nosimd
+
+#include "textflag.h"
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// func countByteAVX2(data []byte, target byte) int
+TEXT ·countByteAVX2(SB), NOSPLIT, $0-40
+	MOVQ data_base+0(FP), SI
+	MOVQ data_len+8(FP), CX
+	MOVBLZX target+24(FP), AX
+	XORQ R8, R8
+
+	// Broadcast the target byte to all 32 lanes of Y0
+	MOVD AX, X0
+	VPBROADCASTB X0, Y0
+
+loop:
+	CMPQ CX, $32
+	JB done
+	VMOVDQU (SI), Y1
+	VPCMPEQB Y0, Y1, Y1
+	VPMOVMSKB Y1, DX
+	POPCNTL DX, DX
+	ADDQ DX, R8
+	ADDQ $32, SI
+	SUBQ $32, CX
+	JMP loop
+
+done:
+	VZEROUPPER
+	MOVQ R8, ret+32(FP)
+	RET
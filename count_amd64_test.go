@@ -0,0 +1,35 @@
+//go:build amd64 && !nosimd
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCountByteAVX2MatchesGeneric(t *testing.T) {
+	if !hasAVX2 {
+		t.Skip("AVX2 not supported")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 4096)
+	for i := range data {
+		// A small alphabet so the target byte is dense
+		data[i] = byte('a' + rng.Intn(4))
+	}
+
+	for _, bounds := range [][2]int{{0, 0}, {0, 31}, {0, 32}, {1, 33}, {3, 100}, {0, 4096}, {17, 4001}} {
+		start, end := bounds[0], bounds[1]
+		want := countByteGeneric(data, start, end, 'a')
+		if got := countByte(data, start, end, 'a'); got != want {
+			t.Errorf("countByte(%d, %d) = %d, want %d", start, end, got, want)
+		}
+		if n := (end - start) &^ 31; n > 0 {
+			want := countByteGeneric(data, start, start+n, 'a')
+			if got := countByteAVX2(data[start:start+n], 'a'); got != want {
+				t.Errorf("countByteAVX2(%d, %d) = %d, want %d", start, start+n, got, want)
+			}
+		}
+	}
+}
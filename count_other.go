@@ -0,0 +1,8 @@
+//go:build !amd64 || nosimd
+
+package main
+
+// countByte returns how many times target appears in data[start:end].
+func countByte(data []byte, start, end int, target byte) int {
+	return countByteGeneric(data, start, end, target)
+}
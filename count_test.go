@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestProcessCountOnly(t *testing.T) {
+	for _, contents := range []string{"a;1.0\nb;2.0\nc;3.0\n", "a;1.0\nb;2.0\nc;3.0"} {
+		for _, workers := range []int{1, 4} {
+			got := runProcess(t, contents, options{workers: workers, countOnly: true})
+			if got != "3\n" {
+				t.Errorf("workers=%d, %q: got %q, want 3", workers, contents, got)
+			}
+		}
+	}
+}
+
+// BenchmarkCountByte runs countByte, the AVX2 path on amd64, and the
+// portable loop side by side over buffers of several sizes with the target
+// at several densities. Building with -tags nosimd makes countByte the
+// portable loop too, for comparing against a scalar build.
+func BenchmarkCountByte(b *testing.B) {
+	impls := []struct {
+		name  string
+		count func(data []byte, start, end int, target byte) int
+	}{
+		{"countByte", countByte},
+		{"generic", countByteGeneric},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{64, 4 << 10, 1 << 20} {
+		for _, every := range []int{2, 16, 1024} {
+			// The target is about one byte in every, like a newline after
+			// lines of that length
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = 'a'
+				if rng.Intn(every) == 0 {
+					data[i] = '\n'
+				}
+			}
+			for _, impl := range impls {
+				b.Run(fmt.Sprintf("%s/size=%d/every=%d", impl.name, size, every), func(b *testing.B) {
+					b.SetBytes(int64(size))
+					for i := 0; i < b.N; i++ {
+						impl.count(data, 0, size, '\n')
+					}
+				})
+			}
+		}
+	}
+}
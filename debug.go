@@ -0,0 +1,27 @@
+//go:build debug
+
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// checkBlockStart panics if the block at data[start] begins part way
+// through a UTF-8 sequence: on a continuation byte that doesn't follow an
+// eol byte. Blocks are cut after eol bytes, which are ASCII, so that only
+// happens if the boundary logic is broken; a continuation byte just after
+// an eol is invalid input rather than a split, and is left to the parser.
+func checkBlockStart(data []byte, start int, eol byte) {
+	if start == 0 || start >= len(data) || utf8.RuneStart(data[start]) || data[start-1] == eol {
+		return
+	}
+	from, to := start-8, start+8
+	if from < 0 {
+		from = 0
+	}
+	if to > len(data) {
+		to = len(data)
+	}
+	panic(fmt.Sprintf("block at byte %d starts inside a UTF-8 sequence: % x | % x", start, data[from:start], data[start:to]))
+}
@@ -0,0 +1,24 @@
+//go:build debug
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckBlockStart(t *testing.T) {
+	data := []byte("Zürich;1.0\n\x80bad;2.0\n")
+	// Line starts, and a stray continuation byte right after a newline
+	for _, start := range []int{0, 12, 13, len(data)} {
+		checkBlockStart(data, start, '\n')
+	}
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "block at byte 2 starts inside a UTF-8 sequence") {
+			t.Errorf("got panic %q", msg)
+		}
+	}()
+	checkBlockStart(data, 2, '\n') // the second byte of ü
+}
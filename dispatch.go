@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// runDispatched implements -dispatch-batch: a single scanner cuts
+// data[start:end], which must begin at the start of a line, into
+// line-aligned batches of about opts.dispatchBatch bytes and hands them
+// over a channel to opts.workers workers, each aggregating into its own
+// accumulator. A worker that draws cheap batches simply draws more of
+// them, so skewed input, where byte-equal blocks hold very different row
+// counts, no longer leaves most workers idle waiting on the slowest.
+//
+// Rejected lines are reported in the order the workers found them rather
+// than in file order.
+func runDispatched(data []byte, start, end int, opts *options) []*chunkResult {
+	numWorkers := opts.workers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	batches := make(chan block, 2*numWorkers)
+	results := make([]*chunkResult, numWorkers)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := range results {
+		go func(i int) {
+			defer wg.Done()
+			res := &chunkResult{acc: opts.newAccumulator()(1 << 14)}
+			opts.maxStations.watch(res.acc)
+			for blk := range batches {
+				if opts.throttle != nil {
+					opts.throttle.wait(blk.end - blk.start)
+				} else if opts.parallelPrefetch {
+					adviseBlock(data, blk.start, blk.end)
+				}
+				res.absorb(processBlock(data, blk.start, blk.end, opts, res.acc))
+			}
+			results[i] = res
+			opts.partials.report(i, res)
+			opts.trace.report(i, nil, res)
+		}(i)
+	}
+
+	eol := opts.eol()
+	for blockStart := start; blockStart < end; {
+		blockEnd := blockStart + opts.dispatchBatch
+		if blockEnd >= end {
+			blockEnd = end
+		} else if nl := bytes.IndexByte(data[blockEnd:end], eol); nl >= 0 {
+			blockEnd += nl + 1
+		} else {
+			blockEnd = end
+		}
+		batches <- block{blockStart, blockEnd}
+		blockStart = blockEnd
+	}
+	close(batches)
+
+	wg.Wait()
+	return results
+}
+
+// absorb adds the rejections, skips and line stats of r, a later range
+// processed into the same accumulator, to res.
+func (res *chunkResult) absorb(r *chunkResult) {
+	res.rejected.merge(r.rejected)
+	res.skipped.merge(r.skipped)
+	res.clamped += r.clamped
+	res.lengths.merge(r.lengths)
+}
+
+// processRange processes data[start:end], which must begin at the start of
+// a line, with opts.workers workers, or as many as -workers=auto picks,
+// handing out batches under -dispatch-batch and otherwise one equal block
+// per worker.
+func processRange(data []byte, start, end int, opts *options) []*chunkResult {
+	if opts.autoWorkers {
+		return processAutoWorkers(data, start, end, opts)
+	}
+	if opts.dispatchBatch > 0 {
+		return runDispatched(data, start, end, opts)
+	}
+	return runWorkers(data, splitBlocks(data, start, end, opts.workers, opts.eol()), opts)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// BenchmarkProcessParallelPrefetch compares the default single sequential
+// advice on the mapping with each worker advising its own block. The
+// difference only shows on a cold page cache, so drop the cache between runs
+// (e.g. with -count=1 and echo 3 > /proc/sys/vm/drop_caches) to measure it.
+func BenchmarkProcessParallelPrefetch(b *testing.B) {
+	path := writeBenchFile(b, 1_000_000)
+	for _, prefetch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel-prefetch=%v", prefetch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := process(io.Discard, path, options{workers: runtime.NumCPU(), parallelPrefetch: prefetch}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProcessDispatchSkewed compares the static equal-bytes split with
+// -dispatch-batch on a file whose first half has long station names and
+// second half short ones, so the blocks of the second half hold far more
+// rows. It needs several CPUs for the tail of the static split to show.
+func BenchmarkProcessDispatchSkewed(b *testing.B) {
+	var buf bytes.Buffer
+	long := strings.Repeat("x", 90)
+	for i := 0; i < 200_000; i++ {
+		fmt.Fprintf(&buf, "%s%03d;%d.%d\n", long, i%413, i%90, i%10)
+	}
+	for i := 0; i < 2_000_000; i++ {
+		fmt.Fprintf(&buf, "s%03d;%d.%d\n", i%413, i%90, i%10)
+	}
+	path := filepath.Join(b.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, batch := range []int{0, 1 << 20} {
+		b.Run(fmt.Sprintf("dispatch-batch=%d", batch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := process(io.Discard, path, options{workers: runtime.NumCPU(), dispatchBatch: batch}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessParallelPrefetch(t *testing.T) {
+	// Enough rows that most blocks start mid-page
+	var buf strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%37, i%90, i%10)
+	}
+	contents := buf.String()
+	want := runProcess(t, contents, options{workers: 1})
+	for _, workers := range []int{2, 7} {
+		if got := runProcess(t, contents, options{workers: workers, parallelPrefetch: true}); got != want {
+			t.Errorf("%d workers: got %.200q, want %.200q", workers, got, want)
+		}
+	}
+}
+
+func TestProcessDispatchBatch(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%37, i%90, i%10)
+		if i%1000 == 0 {
+			buf.WriteString("bad line\n")
+		}
+	}
+	// Ends without a newline, inside the last batch
+	buf.WriteString("Tail;1.0")
+	contents := buf.String()
+
+	var wantDiag bytes.Buffer
+	want := runProcess(t, contents, options{workers: 1, stats: true, diag: &wantDiag})
+	for _, batch := range []int{1, 100, 4096, 1 << 20} {
+		for _, windowSize := range []int64{0, 16 << 10} {
+			var diag bytes.Buffer
+			opts := options{workers: 3, dispatchBatch: batch, windowSize: windowSize, stats: true, diag: &diag}
+			if got := runProcess(t, contents, opts); got != want {
+				t.Errorf("batch %d, window %d: got %.200q, want %.200q", batch, windowSize, got, want)
+			}
+			if diag.String() != wantDiag.String() {
+				t.Errorf("batch %d, window %d: skips %q, want %q", batch, windowSize, diag.String(), wantDiag.String())
+			}
+		}
+	}
+}
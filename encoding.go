@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// utf16ChunkSize is how many bytes of UTF-8 a utf16Reader decodes at a
+// time
+const utf16ChunkSize = 64 << 10
+
+// utf16Reader decodes UTF-16 input to UTF-8 for -encoding, so the parser,
+// which only knows ASCII-compatible bytes, can read a Windows export. A
+// byte order mark decodes to the UTF-8 one, which the stream then skips.
+// Unpaired surrogates and a trailing odd byte decode to U+FFFD, as a
+// station name the user can spot rather than an error part way through.
+//
+// It can only feed processStream: the decoded bytes have to be produced in
+// order, so UTF-16 input is never mapped.
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+
+	pending    rune // a unit read past an unpaired high surrogate
+	hasPending bool
+
+	buf []byte // decoded bytes not yet returned
+	out []byte
+	err error
+}
+
+func newUTF16Reader(r io.Reader, bigEndian bool) *utf16Reader {
+	return &utf16Reader{r: bufio.NewReader(r), bigEndian: bigEndian, buf: make([]byte, 0, utf16ChunkSize+utf8.UTFMax)}
+}
+
+func (d *utf16Reader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.fill()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// fill decodes the next chunk of input into out, setting err once the
+// input ends.
+func (d *utf16Reader) fill() {
+	out := d.buf[:0]
+	for len(out) < utf16ChunkSize {
+		r, err := d.unit()
+		if err == nil && utf16.IsSurrogate(r) {
+			// Only a high surrogate followed by a low one is a pair; any
+			// other unit after it is decoded by itself next
+			var low rune
+			if low, err = d.unit(); err == nil {
+				if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+					d.pending, d.hasPending = low, true
+				}
+			}
+		}
+		if err == io.ErrUnexpectedEOF || err != nil && utf16.IsSurrogate(r) {
+			out = utf8.AppendRune(out, utf8.RuneError)
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			d.err = err
+			break
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	d.out = out
+}
+
+// unit returns the next UTF-16 code unit, with io.ErrUnexpectedEOF if the
+// input ends half way through one.
+func (d *utf16Reader) unit() (rune, error) {
+	if d.hasPending {
+		d.hasPending = false
+		return d.pending, nil
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return 0, err
+	}
+	if d.bigEndian {
+		return rune(b[0])<<8 | rune(b[1]), nil
+	}
+	return rune(b[1])<<8 | rune(b[0]), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16 with a byte order mark.
+func encodeUTF16(s string, bigEndian bool) []byte {
+	var out []byte
+	for _, u := range utf16.Encode([]rune("\ufeff" + s)) {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+	return out
+}
+
+func TestProcessEncodingUTF16(t *testing.T) {
+	contents := "Zürich;1.0\n🌡 Station;-2.5\nZürich;3.0\n"
+	want := "{Zürich=1.0/2.0/3.0, 🌡 Station=-2.5/-2.5/-2.5}\n"
+	for _, encoding := range []string{"utf16le", "utf16be"} {
+		path := writeTempFile(t, string(encodeUTF16(contents, encoding == "utf16be")))
+		var out bytes.Buffer
+		if err := process(&out, path, options{workers: 2, encoding: encoding, windowSize: 32}); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: got %q, want %q", encoding, out.String(), want)
+		}
+	}
+}
+
+func TestUTF16ReaderMalformed(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte{'a', 0, 0x00, 0xd8, 'b', 0}, "a�b"},        // unpaired high surrogate
+		{[]byte{0x00, 0xdc, 'b', 0}, "�b"},                 // unpaired low surrogate
+		{[]byte{0x00, 0xd8, 0x3c, 0xd8, 0x21, 0xdf}, "�🌡"}, // high before a pair
+		{[]byte{'a', 0, 0x3c, 0xd8}, "a�"},                 // high surrogate at the end
+		{[]byte{'a', 0, 'b'}, "a�"},                        // odd byte at the end
+	}
+	for _, tt := range tests {
+		got, err := io.ReadAll(iotest.OneByteReader(newUTF16Reader(bytes.NewReader(tt.in), false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%x: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+// stationSet is a fixed set of station names, for -exclude. It's a
+// hashtable with no hot cache, so once built the workers can share it for
+// lookups; the hash of each name is the one the scan already has.
+type stationSet struct {
+	ht *hashtable
+}
+
+func newStationSet(names [][]byte) *stationSet {
+	numBuckets := 2 * uint64(len(names))
+	if numBuckets == 0 {
+		numBuckets = 1
+	}
+	ht := NewHashTable(numBuckets)
+	for _, key := range names {
+		hash := hashBytes(key, 0, len(key))
+		if ht.get(hash, key) == nil {
+			ht.add(hash, key, &stats{})
+		}
+	}
+	return &stationSet{ht: ht}
+}
+
+// contains reports whether key, whose hash is hash, is in s. A nil set
+// contains nothing.
+func (s *stationSet) contains(hash fnvHash, key []byte) bool {
+	return s != nil && s.ht.get(hash, key) != nil
+}
+
+// withoutExcluded returns the seeds that aren't in excluded, so an
+// excluded station isn't printed even with -keep-empty: the denylist takes
+// precedence over -seed-stations.
+func withoutExcluded(seeds [][]byte, excluded *stationSet) [][]byte {
+	if excluded == nil || seeds == nil {
+		return seeds
+	}
+	// kept stays non-nil, so -strict still checks against the seeds
+	// even when every one of them is excluded
+	kept := make([][]byte, 0, len(seeds))
+	for _, key := range seeds {
+		if !excluded.contains(hashBytes(key, 0, len(key)), key) {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// excludedNames loads the names of an -exclude file, one per line, in the
+// -seed-stations format.
+func excludedNames(fileName string) (*stationSet, error) {
+	names, err := loadSeedStations(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newStationSet(names), nil
+}
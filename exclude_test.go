@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessExclude(t *testing.T) {
+	dir := t.TempDir()
+	excludeFile := filepath.Join(dir, "exclude.txt")
+	if err := os.WriteFile(excludeFile, []byte("b\r\nc\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	excluded, err := excludedNames(excludeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeded := [][]byte{[]byte("a"), []byte("bb"), []byte("c"), []byte("d")}
+
+	contents := "a;-1.0\nb;2.0\nbb;4.0\na;3.0\nc;9.9\n"
+	for _, opts := range []options{
+		{workers: 2},
+		{workers: 2, lowMem: true},
+		{workers: 2, windowSize: 4096},
+		{workers: 2, strict: true},
+	} {
+		opts.excluded = excluded
+		if got, want := runProcess(t, contents, opts), "{a=-1.0/1.0/3.0, bb=4.0/4.0/4.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+
+		// c is both seeded and excluded, and the exclusion wins
+		opts.seeds = withoutExcluded(seeded, excluded)
+		opts.keepEmpty = true
+		if got, want := runProcess(t, contents, opts), "{a=-1.0/1.0/3.0, bb=4.0/4.0/4.0, d=NA/NA/NA}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	// Excluding every seed still leaves -strict checking against them
+	if seeds := withoutExcluded([][]byte{[]byte("b")}, excluded); seeds == nil || len(seeds) != 0 {
+		t.Errorf("got %q, want no seeds", seeds)
+	}
+	if seeds := withoutExcluded(nil, excluded); seeds != nil {
+		t.Errorf("got %q, want nil", seeds)
+	}
+}
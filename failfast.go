@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// maxFailureSnippet is how much of the first malformed line -fail-fast
+// quotes in its error
+const maxFailureSnippet = 80
+
+// firstFailure is the earliest malformed line found by the workers of one
+// range under -fail-fast. A worker stops as soon as it is past that line,
+// so every worker after the failing one stops at its next line while those
+// before it keep going, in case their blocks hold an earlier one. The line
+// reported is therefore always the first malformed line of the range,
+// whatever the worker count.
+type firstFailure struct {
+	at atomic.Int64 // offset of the earliest malformed line, or MaxInt64
+
+	mu   sync.Mutex
+	line []byte
+}
+
+func newFirstFailure() *firstFailure {
+	f := &firstFailure{}
+	f.at.Store(math.MaxInt64)
+	return f
+}
+
+// record notes the malformed line data[lineStart:lineEnd] if it's the
+// earliest found so far.
+func (f *firstFailure) record(data []byte, lineStart, lineEnd int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if int64(lineStart) >= f.at.Load() {
+		return
+	}
+	if lineEnd-lineStart > maxFailureSnippet {
+		lineEnd = lineStart + maxFailureSnippet
+	}
+	f.line = bytes.Clone(data[lineStart:lineEnd])
+	f.at.Store(int64(lineStart))
+}
+
+// passed reports whether offset is beyond the earliest malformed line, so
+// a worker that got there can stop.
+func (f *firstFailure) passed(offset int) bool {
+	return int64(offset) > f.at.Load()
+}
+
+// err returns the error for the earliest malformed line, if any, giving
+// its offset in the input for a range that starts base bytes in.
+func (f *firstFailure) err(base int64) error {
+	if f == nil || f.at.Load() == math.MaxInt64 {
+		return nil
+	}
+	return fmt.Errorf("malformed line at byte %d: %q", base+f.at.Load(), f.line)
+}
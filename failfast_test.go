@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestProcessFailFast(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 3000; i++ {
+		if i == 1800 {
+			// A later worker's block fails too, and may get there first
+			buf.WriteString("late;oops\n")
+		}
+		if i == 1200 {
+			fmt.Fprintf(&buf, "first;bad %s\n", strings.Repeat("x", 100))
+		}
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%37, i%90, i%10)
+	}
+	contents := buf.String()
+	offset := strings.Index(contents, "first;")
+	want := fmt.Sprintf("malformed line at byte %d: %q", offset, contents[offset:offset+maxFailureSnippet])
+
+	for _, opts := range []options{
+		{workers: 1, failFast: true},
+		{workers: 6, failFast: true},
+		{workers: 3, failFast: true, windowSize: 4096},
+		{workers: 3, failFast: true, dispatchBatch: 512},
+	} {
+		var out bytes.Buffer
+		err := process(&out, writeTempFile(t, contents), opts)
+		if err == nil || err.Error() != want {
+			t.Errorf("%+v: got error %v, want %s", opts, err, want)
+		}
+		if out.Len() != 0 {
+			t.Errorf("%+v: wrote %q despite failing", opts, out.String())
+		}
+	}
+
+	if got := runProcess(t, "a;1.0\nb;2.0\n", options{workers: 4, failFast: true}); got != "{a=1.0/1.0/1.0, b=2.0/2.0/2.0}\n" {
+		t.Errorf("well formed input: got %q", got)
+	}
+}
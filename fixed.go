@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fixedLayout describes records made of a space-padded station field
+// followed by a temperature field, with no delimiter between them.
+type fixedLayout struct {
+	station int
+	temp    int
+}
+
+// parseFixedLayout parses a -fixed value of the form "station,temp".
+func parseFixedLayout(s string) (fixedLayout, error) {
+	stationWidth, tempWidth, ok := strings.Cut(s, ",")
+	if !ok {
+		return fixedLayout{}, fmt.Errorf("invalid -fixed %q: want \"station,temp\" widths", s)
+	}
+	station, err := strconv.Atoi(strings.TrimSpace(stationWidth))
+	if err != nil || station < 1 {
+		return fixedLayout{}, fmt.Errorf("invalid -fixed station width %q", stationWidth)
+	}
+	temp, err := strconv.Atoi(strings.TrimSpace(tempWidth))
+	if err != nil || temp < 1 {
+		return fixedLayout{}, fmt.Errorf("invalid -fixed temperature width %q", tempWidth)
+	}
+	return fixedLayout{station: station, temp: temp}, nil
+}
+
+// processFixedData is the fixed-width counterpart of processData. Each line
+// is sliced by the layout's widths rather than scanned for a delimiter, and
+// lines too short to hold a temperature are skipped, or rejected if strict.
+// -stats validates and counts skips as processData does.
+func processFixedData(data []byte, start int, endPos int, layout fixedLayout, opts *options, acc Accumulator) *chunkResult {
+	res := &chunkResult{acc: acc, failure: opts.failure}
+
+	strict := opts.strict
+	validating := strict || opts.stats || opts.failure != nil
+	parser := newFieldParser(opts, validating)
+	var rec record
+	recorder, _ := acc.(RowRecorder)
+	if !opts.recordsRows() {
+		recorder = nil
+	}
+
+	eol := opts.eol()
+	i := start
+	for i < endPos {
+		if opts.failure != nil && opts.failure.passed(i) {
+			break
+		}
+		if opts.maxStations.hit() {
+			break
+		}
+		if opts.comment != 0 && data[i] == opts.comment {
+			i = nextLine(data, i, endPos, eol)
+			continue
+		}
+
+		lineEnd := i
+		for ; lineEnd < endPos && data[lineEnd] != eol; lineEnd++ {
+		}
+		lineStart := i
+		line := data[i:lineEnd]
+		i = lineEnd + 1
+
+		if len(line) <= layout.station {
+			if validating {
+				why := skipTooShort
+				if len(line) == 0 {
+					why = skipEmpty
+				}
+				res.skip(why, data, lineStart, lineEnd, strict)
+			}
+			continue
+		}
+		tempEnd := layout.station + layout.temp
+		if tempEnd > len(line) {
+			tempEnd = len(line)
+		}
+
+		stationKey := bytes.Trim(line[:layout.station], " ")
+		tempBytes := bytes.Trim(line[layout.station:tempEnd], " ")
+		verdict, why := parser.parse(stationKey, tempBytes, &rec)
+		if verdict != recordAccepted {
+			if verdict == recordMalformed {
+				res.skip(why, data, lineStart, lineEnd, strict)
+			}
+			continue
+		}
+		if rec.clamped {
+			res.clamped++
+		}
+
+		if recorder != nil {
+			var text []byte
+			if opts.keepExtremes {
+				text = rec.text
+			}
+			recorder.UpdateRow(rec.key, rec.hash, rec.temp, int64(lineStart), text, rec.at)
+		} else {
+			acc.Update(rec.key, rec.hash, rec.temp)
+		}
+		if opts.lineStats {
+			res.lengths.add(len(rec.key), len(line))
+		}
+	}
+	return res
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessFixedWidth(t *testing.T) {
+	contents := "" +
+		"Abha      " + "  12.3\n" +
+		"Las Vegas " + " -4.5\n" +
+		"Abha      " + "-10.1\n" +
+		"short\n"
+	layout := fixedLayout{station: 10, temp: 6}
+	for _, workers := range []int{1, 4} {
+		got := runProcess(t, contents, options{workers: workers, fixed: &layout})
+		want := "{Abha=-10.1/1.1/12.3, Las Vegas=-4.5/-4.5/-4.5}\n"
+		if got != want {
+			t.Errorf("workers=%d: got %q, want %q", workers, got, want)
+		}
+	}
+}
+
+func TestParseFixedLayout(t *testing.T) {
+	got, err := parseFixedLayout("32,6")
+	if err != nil || got != (fixedLayout{station: 32, temp: 6}) {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	for _, bad := range []string{"32", "a,6", "32,0", ""} {
+		if _, err := parseFixedLayout(bad); err == nil {
+			t.Errorf("parseFixedLayout(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// follow implements -follow: it aggregates fileName as it stands, prints
+// the result, then polls the file every interval and aggregates whatever
+// has been appended, printing the updated result after each poll that
+// added rows, until done is closed, or forever if it's nil.
+//
+// Only complete lines are aggregated. A last line still being written is
+// left for a later poll to read again once its newline arrives, so lines
+// must be shorter than the buffer, as for processStream. The appended
+// bytes are read rather than mapped, since a mapping can't grow with the
+// file, and as with a stream their stations are copied into one owned
+// table.
+func follow(output io.Writer, fileName string, opts options, interval time.Duration, done <-chan struct{}) error {
+	if err := checkStreamable(&opts); err != nil {
+		return err
+	}
+	file, err := openMeasurements(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bufSize := opts.windowSize
+	if bufSize <= 0 {
+		bufSize = defaultStreamBufferSize
+	}
+	buf := make([]byte, bufSize)
+	totals := newRangeTotals()
+	opts.ownedKeys = true
+
+	offset := int64(0)
+	first := true
+	for {
+		stat, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("cannot stat measurements file %q: %w", fileName, err)
+		}
+		if stat.Size() < offset {
+			return fmt.Errorf("%s shrank from %d to %d bytes", fileName, offset, stat.Size())
+		}
+
+		next, err := totals.catchUp(file, offset, stat.Size(), buf, &opts)
+		if err != nil {
+			return err
+		}
+		if first || next > offset {
+			opts.logf("follow: %d bytes aggregated", next)
+			if err := writeOutput(output, totals.merged, &opts); err != nil {
+				return err
+			}
+		}
+		offset, first = next, false
+
+		select {
+		case <-done:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// catchUp aggregates the complete lines of file between offset and size
+// into t, one buffer at a time, and returns the offset just past the last
+// of them.
+func (t *rangeTotals) catchUp(file *os.File, offset, size int64, buf []byte, opts *options) (int64, error) {
+	for offset < size {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		read, err := file.ReadAt(buf[:n], offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return offset, fmt.Errorf("cannot read measurements: %w", err)
+		}
+		data := buf[:read]
+
+		end := bytes.LastIndexByte(data, opts.eol()) + 1
+		if end == 0 {
+			if read == len(buf) {
+				return offset, fmt.Errorf("line at byte %d is longer than the %d byte buffer", offset, len(buf))
+			}
+			// The last line isn't finished yet
+			return offset, nil
+		}
+
+		start := 0
+		if offset == 0 {
+			start = bomLen(data)
+		}
+		if err := t.add(data, start, end, offset, opts); err != nil {
+			return offset, err
+		}
+		offset += int64(end)
+	}
+	return offset, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanWriter sends each write to a channel, so a test can wait for output
+// written by another goroutine.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestFollow(t *testing.T) {
+	path := writeTempFile(t, "a;1.0\nb;2")
+	out := make(chanWriter)
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- follow(out, path, options{workers: 2, windowSize: 16}, 5*time.Millisecond, done) }()
+
+	next := func() string {
+		select {
+		case s := <-out:
+			return s
+		case err := <-errc:
+			t.Fatalf("follow returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no output")
+		}
+		return ""
+	}
+	appendFile := func(s string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	// The unfinished line waits for its newline
+	if got, want := next(), "{a=1.0/1.0/1.0}\n"; got != want {
+		t.Errorf("initial: got %q, want %q", got, want)
+	}
+	appendFile(".0\na;3.0\nc;-1.0\nb;4.0\n")
+	if got, want := next(), "{a=1.0/2.0/3.0, b=2.0/3.0/4.0, c=-1.0/-1.0/-1.0}\n"; got != want {
+		t.Errorf("appended: got %q, want %q", got, want)
+	}
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// A seeded station printed as empty takes its first rows on a later
+	// poll as if it had never been printed
+	path = writeTempFile(t, "a;1.0\n")
+	done = make(chan struct{})
+	seeded := options{workers: 2, seeds: [][]byte{[]byte("c")}, keepEmpty: true}
+	go func() { errc <- follow(out, path, seeded, 5*time.Millisecond, done) }()
+	if got, want := next(), "{a=1.0/1.0/1.0, c=NA/NA/NA}\n"; got != want {
+		t.Errorf("seeded: got %q, want %q", got, want)
+	}
+	appendFile("c;5.0\nc;7.0\n")
+	if got, want := next(), "{a=1.0/1.0/1.0, c=5.0/6.0/7.0}\n"; got != want {
+		t.Errorf("seeded, appended: got %q, want %q", got, want)
+	}
+	appendFile("a;3.0\n")
+	if got, want := next(), "{a=1.0/2.0/3.0, c=5.0/6.0/7.0}\n"; got != want {
+		t.Errorf("seeded, appended again: got %q, want %q", got, want)
+	}
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that shrinks can't be followed
+	if err := os.WriteFile(path, []byte("a;1.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	done = make(chan struct{})
+	go func() { errc <- follow(out, path, options{workers: 1}, 5*time.Millisecond, done) }()
+	next()
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "shrank") {
+		t.Errorf("shrunk file: got %v", err)
+	}
+}
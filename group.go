@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseGroupBy parses a -group-by spec, prefix:N, returning N.
+func parseGroupBy(spec string) (int, error) {
+	rest, ok := strings.CutPrefix(spec, "prefix:")
+	if !ok {
+		return 0, fmt.Errorf("-group-by must be prefix:N, got %q", spec)
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("-group-by prefix:N needs a positive N, got %q", rest)
+	}
+	return n, nil
+}
+
+// groupLen returns how many leading bytes of name make its -group-by key:
+// the first n, less any part of a UTF-8 sequence they'd cut, so a group
+// is still printable. A name of n bytes or fewer is its own group.
+func groupLen(name []byte, n int) int {
+	if len(name) <= n {
+		return len(name)
+	}
+	for i := n; i > n-utf8.UTFMax && i > 0; i-- {
+		if utf8.RuneStart(name[i]) {
+			return i
+		}
+	}
+	return n
+}
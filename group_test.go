@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessGroupBy(t *testing.T) {
+	contents := "DE-Berlin;1.0\nDE-Hamburg;3.0\nFR-Paris;-2.0\nDE;5.0\nFR-Lyon;4.0\nZü;7.0\nZürich;9.0\n"
+	// "Zü" is three bytes, so a prefix of two backs off to "Z"
+	want := "{DE=1.0/3.0/5.0, FR=-2.0/1.0/4.0, Z=7.0/8.0/9.0}\n"
+	for _, opts := range []options{{workers: 1}, {workers: 3}, {workers: 2, stats: true}} {
+		opts.groupPrefix = 2
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	layout := fixedLayout{station: 10, temp: 5}
+	got := runProcess(t, "DE-Berlin  1.0\nDE-Bonn    3.0\n", options{workers: 1, fixed: &layout, groupPrefix: 2})
+	if want := "{DE=1.0/2.0/3.0}\n"; got != want {
+		t.Errorf("fixed: got %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"prefix:0", "prefix:x", "suffix:2", "3"} {
+		if _, err := parseGroupBy(bad); err == nil {
+			t.Errorf("parseGroupBy(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import "sort"
+
+// maxHistogramBins caps the dense part of a histogram at this many tenths
+// of a degree, so a few wild readings accepted by -parse-mode=strict can't
+// make it huge. Readings beyond it are counted in far instead.
+const maxHistogramBins = 1 << 14
+
+// histogram counts a station's readings by value, for -iqr. The counts are
+// dense over the range of readings seen so far, which for real stations is
+// at most a few thousand tenths.
+type histogram struct {
+	lo     int32    // the reading counted by counts[0]
+	counts []uint64 // counts[i] is the number of readings of lo+i
+	total  uint64
+	far    map[int32]uint64
+}
+
+func (h *histogram) add(temp int32, n uint64) {
+	h.total += n
+	if len(h.counts) == 0 {
+		h.lo = temp
+		h.counts = make([]uint64, 1, 64)
+	}
+
+	lo, hi := int64(h.lo), int64(h.lo)+int64(len(h.counts))
+	t := int64(temp)
+	switch {
+	case t >= lo && t < hi:
+		h.counts[t-lo] += n
+		return
+	case t < lo && hi-t <= maxHistogramBins:
+		grown := make([]uint64, hi-t, cap(h.counts)+int(lo-t))
+		copy(grown[lo-t:], h.counts)
+		h.counts, h.lo = grown, temp
+		h.counts[0] += n
+		return
+	case t >= hi && t-lo < maxHistogramBins:
+		for int64(len(h.counts)) <= t-lo {
+			h.counts = append(h.counts, 0)
+		}
+		h.counts[t-lo] += n
+		return
+	}
+
+	if h.far == nil {
+		h.far = make(map[int32]uint64)
+	}
+	h.far[temp] += n
+}
+
+// merge adds the readings counted by o to h.
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.counts {
+		if n > 0 {
+			h.add(o.lo+int32(i), n)
+		}
+	}
+	for temp, n := range o.far {
+		h.add(temp, n)
+	}
+}
+
+// nth returns the rank'th smallest reading, counting from 1.
+func (h *histogram) nth(rank uint64) int32 {
+	far := make([]int32, 0, len(h.far))
+	for temp := range h.far {
+		far = append(far, temp)
+	}
+	sort.Slice(far, func(i, j int) bool { return far[i] < far[j] })
+
+	var seen uint64
+	k := 0
+	for i, n := range h.counts {
+		temp := h.lo + int32(i)
+		for ; k < len(far) && far[k] < temp; k++ {
+			if seen += h.far[far[k]]; seen >= rank {
+				return far[k]
+			}
+		}
+		if seen += n; n > 0 && seen >= rank {
+			return temp
+		}
+	}
+	for ; k < len(far); k++ {
+		if seen += h.far[far[k]]; seen >= rank {
+			return far[k]
+		}
+	}
+	return 0
+}
+
+// iqrTenths returns p75 - p25 in tenths of a degree, taking each
+// percentile as the nearest-rank reading: the ceil(p*n/100)'th smallest.
+func (h *histogram) iqrTenths() int64 {
+	p25 := h.nth((h.total + 3) / 4)
+	p75 := h.nth((3*h.total + 3) / 4)
+	return int64(p75) - int64(p25)
+}
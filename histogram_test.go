@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHistogramIQR(t *testing.T) {
+	tests := []struct {
+		name  string
+		temps []int32
+		want  int64
+	}{
+		{"one reading", []int32{42}, 0},
+		// An outlier barely moves the quartiles
+		{"skewed", []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 1000}, 6},
+		{"descending", []int32{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 5},
+		{"beyond the dense range", []int32{0, 100000, 5, -100000, 7}, 7},
+		{"mostly one value", []int32{-50, -50, -50, -50, -50, -50, 300, 300}, 0},
+		{"two clusters", []int32{-50, 300, -50, 300, -50, 300, -50, 300}, 350},
+	}
+	for _, tt := range tests {
+		var whole histogram
+		var halves [2]histogram
+		for i, temp := range tt.temps {
+			whole.add(temp, 1)
+			halves[i%2].add(temp, 1)
+		}
+		halves[0].merge(&halves[1])
+		if got := whole.iqrTenths(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+		if got := halves[0].iqrTenths(); got != tt.want {
+			t.Errorf("%s, merged: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessIQR(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 400; i++ {
+		// a is skewed low with a long hot tail, b is two clusters
+		fmt.Fprintf(&buf, "a;%d.%d\n", i%10, i%7)
+		if i%40 == 0 {
+			fmt.Fprintf(&buf, "a;9%d.0\n", i/40)
+		}
+		fmt.Fprintf(&buf, "b;%d.0\n", (i%2)*20-10)
+	}
+	contents := buf.String()
+
+	want := "{a=0.0/7.0/99.0/5.1, b=-10.0/0.0/10.0/20.0}\n"
+	for _, opts := range []options{
+		{workers: 1, iqr: true},
+		{workers: 5, iqr: true},
+		{workers: 2, iqr: true, windowSize: 4096},
+		{workers: 2, iqr: true, dispatchBatch: 100},
+	} {
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	got := runProcess(t, "a;1.0\na;3.0\n", options{workers: 1, iqr: true, format: "json"})
+	if want := `{"a":{"min":1.0,"mean":2.0,"max":3.0,"count":2,"iqr":2.0}}` + "\n"; got != want {
+		t.Errorf("json: got %q, want %q", got, want)
+	}
+}
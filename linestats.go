@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// lengthStats summarises a distribution of byte lengths.
+type lengthStats struct {
+	count    uint64
+	total    uint64
+	min, max int
+}
+
+func (l *lengthStats) add(n int) {
+	if l.count == 0 || n < l.min {
+		l.min = n
+	}
+	if n > l.max {
+		l.max = n
+	}
+	l.count++
+	l.total += uint64(n)
+}
+
+func (l *lengthStats) merge(o lengthStats) {
+	if o.count == 0 {
+		return
+	}
+	if l.count == 0 || o.min < l.min {
+		l.min = o.min
+	}
+	if o.max > l.max {
+		l.max = o.max
+	}
+	l.count += o.count
+	l.total += o.total
+}
+
+// lineStats are the lengths of the station names and whole lines, without
+// the newline, of the rows a worker aggregated, for -line-stats.
+type lineStats struct {
+	names, lines lengthStats
+}
+
+func (s *lineStats) add(nameLen, lineLen int) {
+	s.names.add(nameLen)
+	s.lines.add(lineLen)
+}
+
+func (s *lineStats) merge(o lineStats) {
+	s.names.merge(o.names)
+	s.lines.merge(o.lines)
+}
+
+// totalLineStats adds up the line stats of all workers.
+func totalLineStats(results []*chunkResult) lineStats {
+	var total lineStats
+	for _, r := range results {
+		total.merge(r.lengths)
+	}
+	return total
+}
+
+// reportLineStats writes the -line-stats summary to w.
+func reportLineStats(w io.Writer, s lineStats) {
+	if w == nil {
+		return
+	}
+	for _, l := range []struct {
+		what string
+		lengthStats
+	}{{"station names", s.names}, {"lines", s.lines}} {
+		if l.count == 0 {
+			fmt.Fprintf(w, "%s: no rows\n", l.what)
+			continue
+		}
+		fmt.Fprintf(w, "%s: min %d, max %d, avg %.1f bytes over %d rows\n",
+			l.what, l.min, l.max, float64(l.total)/float64(l.count), l.count)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProcessLineStats(t *testing.T) {
+	contents := "Abha;1.0\nBonn;-12.0\nbad\nSan Francisco;3.0\n"
+	want := "station names: min 4, max 13, avg 7.0 bytes over 3 rows\n" +
+		"lines: min 8, max 17, avg 11.7 bytes over 3 rows\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 2, windowSize: 4096},
+		{workers: 2, dispatchBatch: 10},
+	} {
+		var diag bytes.Buffer
+		opts.lineStats, opts.stats, opts.diag = true, true, &diag
+		runProcess(t, contents, opts)
+		// -stats reports the malformed line first
+		if got := diag.String(); !strings.HasSuffix(got, want) {
+			t.Errorf("%+v: got %q, want it to end with %q", opts, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// lowMemRunSize is the number of stations sorted in memory at once in
+// -low-mem mode. Each run is written to a temporary file and the runs are
+// k-way merged into the output, so beyond the merged table itself the
+// footprint is one run of this many items plus a read buffer per run.
+// -low-mem's usage text quotes it.
+const lowMemRunSize = 1 << 16
+
+// runRecordHeaderLen is the size of a run record before its key: the key
+// length, min, max, sum, count, first and last offsets, the lengths of the
+// min and max text, which follow the key, and the high word of the sum.
+const runRecordHeaderLen = 4 + 4 + 4 + 8 + 8 + 8 + 8 + 4 + 4 + 8
+
+// writeLowMem writes the output for res in the order given by less, by
+// sorting it in bounded runs spilled to disk and merging the runs back
+// together.
+func writeLowMem(sinks []sink, res *hashtable, less stationLess, bufSize, flushEvery int, meta *runMeta) error {
+	dir, err := os.MkdirTemp("", "1brc-runs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var runs []*runReader
+	defer func() {
+		for _, r := range runs {
+			r.file.Close()
+		}
+	}()
+
+	run := make([]item, 0, lowMemRunSize)
+	flush := func() error {
+		if len(run) == 0 {
+			return nil
+		}
+		sortItemsBy(run, less)
+		r, err := writeRun(dir, run)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, r)
+		run = run[:0]
+		return nil
+	}
+
+	for _, item := range res.items {
+		if item.value == nil {
+			continue
+		}
+		run = append(run, item)
+		if len(run) == lowMemRunSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	run = nil
+
+	h := runHeap{less: less, runs: make([]*runReader, 0, len(runs))}
+	for _, r := range runs {
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(&h)
+
+	o := newFanout(sinks, bufSize, flushEvery, meta)
+	for i := 0; h.Len() > 0; i++ {
+		r := h.runs[0]
+		o.station(i, r.key, &r.stats)
+
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return o.finish()
+}
+
+// writeRun writes a sorted run to a new file in dir and returns a reader
+// positioned at its start.
+func writeRun(dir string, run []item) (*runReader, error) {
+	f, err := os.CreateTemp(dir, "run-")
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	writeRecords(w, run)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &runReader{file: f, r: bufio.NewReader(f)}, nil
+}
+
+// writeRecords writes each item as a run record: a fixed-size header of
+// the key length and stats followed by the key and any -keep-extremes text.
+// Errors are left for the caller's Flush to report.
+func writeRecords(w *bufio.Writer, items []item) {
+	var buf [runRecordHeaderLen]byte
+	for _, item := range items {
+		binary.LittleEndian.PutUint32(buf[0:], uint32(len(item.key)))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(item.value.min))
+		binary.LittleEndian.PutUint32(buf[8:], uint32(item.value.max))
+		binary.LittleEndian.PutUint64(buf[12:], uint64(item.value.sum))
+		binary.LittleEndian.PutUint64(buf[20:], item.value.count)
+		binary.LittleEndian.PutUint64(buf[28:], uint64(item.value.first))
+		binary.LittleEndian.PutUint64(buf[36:], uint64(item.value.last))
+		var minText, maxText []byte
+		if e := item.value.extremes; e != nil {
+			minText, maxText = e.min, e.max
+		}
+		binary.LittleEndian.PutUint32(buf[44:], uint32(len(minText)))
+		binary.LittleEndian.PutUint32(buf[48:], uint32(len(maxText)))
+		binary.LittleEndian.PutUint64(buf[52:], uint64(item.value.sumHi))
+		w.Write(buf[:])
+		w.Write(item.key)
+		w.Write(minText)
+		w.Write(maxText)
+	}
+}
+
+// runReader reads back the records of a run one at a time.
+type runReader struct {
+	file  *os.File
+	r     *bufio.Reader
+	key   []byte
+	stats stats
+}
+
+// next advances to the following record, returning false at the end of the
+// run.
+func (rr *runReader) next() (bool, error) {
+	var buf [runRecordHeaderLen]byte
+	if _, err := io.ReadFull(rr.r, buf[:]); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+
+	keyLen := binary.LittleEndian.Uint32(buf[0:])
+	rr.stats = stats{
+		min:   int32(binary.LittleEndian.Uint32(buf[4:])),
+		max:   int32(binary.LittleEndian.Uint32(buf[8:])),
+		sum:   int64(binary.LittleEndian.Uint64(buf[12:])),
+		count: binary.LittleEndian.Uint64(buf[20:]),
+		first: int64(binary.LittleEndian.Uint64(buf[28:])),
+		last:  int64(binary.LittleEndian.Uint64(buf[36:])),
+		sumHi: int64(binary.LittleEndian.Uint64(buf[52:])),
+	}
+
+	if cap(rr.key) < int(keyLen) {
+		rr.key = make([]byte, keyLen)
+	}
+	rr.key = rr.key[:keyLen]
+	if _, err := io.ReadFull(rr.r, rr.key); err != nil {
+		return false, err
+	}
+
+	// Extremes text is rare enough to allocate per record
+	minLen := binary.LittleEndian.Uint32(buf[44:])
+	maxLen := binary.LittleEndian.Uint32(buf[48:])
+	if minLen > 0 || maxLen > 0 {
+		text := make([]byte, minLen+maxLen)
+		if _, err := io.ReadFull(rr.r, text); err != nil {
+			return false, err
+		}
+		rr.stats.extremes = &extremeText{min: text[:minLen:minLen], max: text[minLen:]}
+	}
+	return true, nil
+}
+
+// runHeap orders run readers by their current station.
+type runHeap struct {
+	less stationLess
+	runs []*runReader
+}
+
+func (h *runHeap) Len() int      { return len(h.runs) }
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x any)    { h.runs = append(h.runs, x.(*runReader)) }
+
+func (h *runHeap) Less(i, j int) bool {
+	return h.less(h.runs[i].key, &h.runs[i].stats, h.runs[j].key, &h.runs[j].stats)
+}
+
+func (h *runHeap) Pop() any {
+	r := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return r
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestProcessLowMemMatchesDefault(t *testing.T) {
+	contents := "Abha;12.3\nBeirut;-4.5\nAbha;-1.0\nCairo;30.1\nBeirut;9.9\nAccra;0.0\n"
+	want := runProcess(t, contents, options{workers: 3})
+	got := runProcess(t, contents, options{workers: 3, lowMem: true})
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteLowMemMultipleRuns(t *testing.T) {
+	ht := NewHashTable(1 << 18)
+	for i := 0; i < 2*lowMemRunSize+100; i++ {
+		key := []byte(fmt.Sprintf("station-%06d", i))
+		ht.add(hashBytes(key, 0, len(key)), key, &stats{min: int32(i), max: int32(i), sum: int64(i), count: 1})
+	}
+
+	var got bytes.Buffer
+	if err := writeLowMem([]sink{{&got, textFormat{}}}, ht, byName, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	populated := populatedItems(ht)
+	sortItems(populated)
+	var want bytes.Buffer
+	if err := writeResults([]sink{{&want, textFormat{}}}, populated, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("low-mem output differs from in-memory sort")
+	}
+}
@@ -1,227 +1,1700 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type stats struct {
 	min   int32
 	max   int32
-	sum   int32
+	sum   int64
 	count uint64
+
+	// sumHi extends sum to 128 bits, as sumHi*2^64 + sum, once it
+	// overflows. It only grows under -wide-sum or in a merge.
+	sumHi int64
+
+	// first and last are the byte offsets of the station's first and last
+	// rows, recorded only under -offsets
+	first int64
+	last  int64
+
+	// extremes is set only under -keep-extremes
+	extremes *extremeText
+
+	// hist is set only under -iqr
+	hist *histogram
+
+	// times is set only under -with-time
+	times *extremeTimes
+}
+
+// extremeText is the original text of the readings that set a station's
+// min and max, so -keep-extremes can tell -0.0 from 0.0.
+type extremeText struct {
+	min, max []byte
+}
+
+// merge folds o into s. s is the earlier part of the input, so as within a
+// worker it keeps its extremes' text when o only ties them.
+func (s *stats) merge(o *stats) {
+	if o.extremes != nil {
+		if s.extremes == nil {
+			s.extremes = &extremeText{}
+		}
+		if o.min < s.min {
+			s.extremes.min = o.extremes.min
+		}
+		if o.max > s.max {
+			s.extremes.max = o.extremes.max
+		}
+	}
+	if o.hist != nil {
+		if s.hist == nil {
+			s.hist = &histogram{}
+		}
+		s.hist.merge(o.hist)
+	}
+	if o.times != nil {
+		if s.times == nil {
+			s.times = &extremeTimes{min: noTime, max: noTime}
+		}
+		if o.min < s.min {
+			s.times.min = o.times.min
+		}
+		if o.max > s.max {
+			s.times.max = o.times.max
+		}
+	}
+	s.min = min(s.min, o.min)
+	s.max = max(s.max, o.max)
+	s.addSum(o.sum)
+	s.sumHi += o.sumHi
+	s.count += o.count
+	if o.first < s.first {
+		s.first = o.first
+	}
+	if o.last > s.last {
+		s.last = o.last
+	}
+}
+
+// addSum adds v to the 128-bit sum, carrying into sumHi on overflow.
+func (s *stats) addSum(v int64) {
+	sum := s.sum + v
+	if v > 0 && sum < s.sum {
+		s.sumHi++
+	} else if v < 0 && sum > s.sum {
+		s.sumHi--
+	}
+	s.sum = sum
+}
+
+var (
+	cpuprofile   = flag.String("cpuprofile", "", "write cpu profile to file")
+	blockprofile = flag.String("blockprofile", "", "write goroutine blocking profile to file")
+	mutexprofile = flag.String("mutexprofile", "", "write mutex contention profile to file")
+	workers      = flag.String("workers", strconv.Itoa(runtime.NumCPU()), "number of worker goroutines, or auto to pick by benchmarking a prefix of the file")
+	reverse      = flag.Bool("reverse-fields", false, "parse lines as temperature;station")
+	trimKeys     = flag.Bool("trim-keys", false, "trim trailing ASCII whitespace from station names")
+	groupBy      = flag.String("group-by", "", "aggregate by `prefix:N`, the first N bytes of each station name, rather than the whole name")
+	format       = flag.String("format", "text", "output format: text, json, ndjson, json-array, counts or gomap, a Go map[string]Stats literal")
+	fixed        = flag.String("fixed", "", "parse fixed-width records of `station,temp` byte widths instead of delimited fields")
+	populate     = flag.Bool("populate", false, "pre-fault the whole mapping with MAP_POPULATE (Linux only)")
+	prefault     = flag.Bool("prefault", false, "touch every page of the mapping before starting the workers")
+	check        = flag.Bool("check", false, "only check that every line parses, without aggregating")
+	countOnly    = flag.Bool("count-only", false, "print only the number of rows")
+	comment      = flag.String("comment", "", "skip lines starting with this `byte`")
+	strict       = flag.Bool("strict", false, "validate every line and fail if any are malformed")
+	lowMem       = flag.Bool("low-mem", false, fmt.Sprintf("sort the output via on-disk runs of %d stations each, so beyond the merged table only one run and a read buffer per run are held in memory", lowMemRunSize))
+	verbose      = flag.Bool("verbose", false, "log what the run is doing to stderr")
+	unit         = flag.String("unit", "c", "temperature unit of the input, c or f; output is always Celsius")
+	parseUnit    = flag.Bool("parse-unit", false, "read a C or F right after a temperature, as in 12.3C, as that line's unit in place of -unit")
+	rename       = flag.String("rename", "", "fold stations into new names using a `file` of from<TAB>to lines")
+	writeBuf     = flag.Int("write-buf", 0, "output buffer size in `bytes`; 0 uses bufio's 4KB, and 64KB is nearly a fifth faster for results of a million stations")
+	withUnits    = flag.Bool("with-units", false, "follow each temperature in the text output with °C, noted in a comment above the result")
+	decimalSep   = flag.String("output-decimal-sep", "", "write temperatures in the text output with this `separator` rather than ., such as , for German reports")
+	minCount     = flag.Uint64("min-count", 0, "leave out stations with fewer than `N` rows; -stats reports how many")
+	meanBelow    = flag.String("mean-below", "", "only print stations whose rounded mean is below this many `degrees`")
+	meanAbove    = flag.String("mean-above", "", "only print stations whose rounded mean is above this many `degrees`; with -mean-below, both must hold")
+	flushEvery   = flag.Int("flush-every", 0, "flush the output after every `K` stations, 1 to write each as soon as it's formatted; 0 flushes only at the end")
+	meta         = flag.Bool("meta", false, "prepend a header with the source file, row count and generation time")
+	maxMBps      = flag.Float64("max-mbps", 0, "limit reading to about this many `MiB` per second; 0 is unlimited")
+	jsonOut      = flag.String("json-out", "", "also write the results as JSON to `file`, gzipped if it ends in .gz")
+	splitOut     = flag.String("split-output", "", "rather than to stdout, write the stations to one file per first byte of their names in `dir`")
+	tenths       = flag.Bool("tenths", false, "read temperatures as signed whole numbers of tenths of a degree, so 123 is 12.3")
+	sciNotation  = flag.Bool("sci-notation", false, "also accept temperatures in scientific notation such as 1.2e1, through a much slower float parse; implies -parse-mode=strict")
+	parseMode    = flag.String("parse-mode", "fast", "temperature parser: fast assumes the canonical d.d or dd.d, strict accepts any digit count and optional decimals")
+	offsets      = flag.Bool("offsets", false, "print the byte offsets of each station's first and last rows instead of its stats")
+	seedStations = flag.String("seed-stations", "", "pre-register the stations listed one per line in `file`; with -strict, any other station is an error")
+	keepEmpty    = flag.Bool("keep-empty", false, "with -seed-stations, also print seeded stations that had no rows")
+	maxStations  = flag.Int("max-stations", 0, "fail once the input has more than `N` distinct stations, such as from a broken upstream; 0 is unlimited")
+	exclude      = flag.String("exclude", "", "drop the rows of the stations listed one per line in `file`, even ones named by -seed-stations")
+	skipStats    = flag.Bool("stats", false, "skip malformed lines and print a breakdown of why to stderr")
+	leaderboard  = flag.Bool("leaderboard", false, "order stations by row count, most first, ties broken by name")
+	orderBy      = flag.String("order-by", "name", "order stations by name, count (as -leaderboard does) or range, widest first")
+	showRange    = flag.Bool("show-range", false, "also write each station's range, max - min")
+	serveAddr    = flag.String("serve", "", "aggregate once, then serve the results over HTTP on `addr`")
+	parPrefetch  = flag.Bool("parallel-prefetch", false, "have each worker advise the kernel to read ahead its own block")
+	collapse     = flag.Bool("collapse-space", false, "collapse runs of spaces in station names to a single space")
+	gzipOut      = flag.Bool("gzip-out", false, "gzip-compress the output")
+	checkUTF8    = flag.Bool("check-utf8", false, "report station names that aren't valid UTF-8; with -strict, fail on them")
+	checkpoint   = flag.String("checkpoint", "", "periodically save the aggregate so far to `file`")
+	ckptInterval = flag.Int("checkpoint-interval", 60, "seconds between -checkpoint saves")
+	resume       = flag.String("resume", "", "continue from a -checkpoint `file` taken over the same input")
+	delimStr     = flag.String("delimiter-str", "", "separate the fields with this `string` rather than ;")
+	clamp        = flag.String("clamp", "", "hold temperatures to `min,max` degrees, counting how many were moved under -stats; with -strict, reject them instead")
+	squeeze      = flag.Bool("squeeze-delim", false, "treat a run of delimiters, as in station;;12.3, as a single one")
+	keepExtremes = flag.Bool("keep-extremes", false, "also print the original text of each station's min and max readings")
+	dispatch     = flag.Int("dispatch-batch", 0, "hand the workers line-aligned batches of about this many `bytes` from a shared queue rather than one equal block each; 0 disables")
+	traceName    = flag.String("trace-station", "", "print each worker's min, max, sum and count for the station `NAME` to stderr before the merge")
+	streamParts  = flag.Bool("stream-partials", false, "print each worker's top stations by count to stderr as it finishes")
+	hashPrefixN  = flag.Int("hash-prefix", 0, "hash at most the first `N` bytes of each station name; names are still compared in full. 0 hashes all of it")
+	probe        = flag.String("probe", "linear", "hashtable probe sequence: linear, or double to step by a second hash of the name, which clusters less when many names hash alike")
+	maxProbesN   = flag.Int("max-probes", defaultMaxProbes, "grow a hashtable once an insert steps over this many `N` occupied slots, if it's at least a quarter full")
+	hashSeedN    = flag.Uint64("hash-seed", 0, "mix this `seed` into station name hashes so untrusted input can't predict collisions; 0 uses plain FNV-1")
+	iqr          = flag.Bool("iqr", false, "also print each station's interquartile range, p75 - p25")
+	withTime     = flag.Bool("with-time", false, "read an optional third field, the row's timestamp, and print when each station's min and max were read")
+	timeFormat   = flag.String("time-format", "rfc3339", "format of -with-time's timestamps: rfc3339, or epoch for Unix seconds")
+	failFast     = flag.Bool("fail-fast", false, "stop at the first malformed line and fail with its offset and text")
+	wideSum      = flag.Bool("wide-sum", false, "keep 128-bit sums so a station can't overflow; needed past ~9.2e15 rows of one station at ±99.9, or ~4.3e9 at the limits -parse-mode=strict saturates to")
+	recordSep    = flag.String("record-sep", "", "end records with this `byte`, given as itself or a Go escape such as \\x00, rather than a newline")
+	hotCache     = flag.Bool("hot-cache", false, "check a small direct-mapped cache of recently seen stations before probing the table")
+	compare      = flag.Bool("compare", false, "aggregate two files and print how each station of the second differs from the first")
+	gomaxprocs   = flag.Int("gomaxprocs", 0, "set GOMAXPROCS to `N`, independently of -workers, which still defaults to the CPU count; 0 leaves it alone")
+	lineLens     = flag.Bool("line-stats", false, "print the min, max and average byte lengths of station names and lines to stderr")
+	sortedIn     = flag.Bool("sorted-input", false, "assume the rows are sorted by station and aggregate each station's run without a hashtable")
+	verifySorted = flag.Bool("verify-sorted", false, "like -sorted-input, but fail if the rows aren't sorted by station")
+	samples      = flag.Int("samples", 0, "rather than min/mean/max, print `K` temperatures per station, drawn uniformly at random from its rows")
+	encoding     = flag.String("encoding", "utf8", "input encoding: utf8, or utf16le or utf16be, which are decoded and streamed rather than mapped")
+	tarIn        = flag.Bool("tar", false, "read the file as a tar archive and aggregate its members together")
+	normalizeNL  = flag.Bool("normalize-newlines", false, "strip the \\r of each \\r\\n as streamed input is read, for Windows files; only streamed input (a pipe such as /dev/stdin, -encoding or -tar) can be normalized, so a regular file is rejected")
+	tarExt       = flag.String("tar-ext", ".txt", "with -tar, only read members whose names end in this; empty reads every regular member")
+	readBelowMB  = flag.Int("read-below", 0, "read files smaller than this many `MB` into memory rather than mapping them")
+	windowMB     = flag.Int("window", 0, "map and process the file in newline-aligned windows of this many `MB`, unmapping each before the next, to bound resident memory; 0 maps it whole")
+	perFile      = flag.Bool("per-file", false, "process each file, or each file in a directory, separately and label its result")
+	manifestOut  = flag.String("manifest-out", "", "also write a JSON description of the run, its input, row and station counts, workers, scan path and elapsed time, to this `file`")
+	quiet        = flag.Bool("quiet", false, "write nothing but the result; errors are still reported")
+	followFlag   = flag.Bool("follow", false, "like tail -f: aggregate the file, then keep aggregating lines appended to it and print the updated result after each poll that found some")
+	followEvery  = flag.Int("follow-interval", 1, "poll a -follow file every this many `seconds`")
+	replFlag     = flag.Bool("repl", false, "aggregate the file once, then look up stations, top N and name prefixes typed at a prompt")
+	sweepFlag    = flag.Bool("sweep", false, "time a sample of the file at 1, 2, 4... up to NumCPU workers and print the throughput of each")
+	selftest     = flag.Int("selftest", 0, "run `N` passes with varying worker counts and check the output is stable")
+)
+
+type options struct {
+	workers       int
+	autoWorkers   bool
+	lowMem        bool
+	fixed         *fixedLayout
+	format        string
+	trimKeys      bool
+	groupPrefix   int         // if set, aggregate by the first this many bytes of each name
+	clamp         *clampRange // if set, hold temperatures to it, or reject those outside it if strict
+	collapseSpace bool        // fold runs of spaces in station names into one
+	gzipOut       bool        // gzip-compress the output
+	checkUTF8     bool        // report station names that aren't valid UTF-8
+	keepExtremes  bool        // keep the original text of each station's min and max
+	iqr           bool        // count readings to report each station's interquartile range
+	withTime      bool        // note when each station's min and max were read
+	epochTime     bool        // -with-time's timestamps are Unix seconds, not RFC 3339
+	wideSum       bool        // carry each station's sum into 128 bits
+	hotCache      bool        // check a small cache of recent stations before probing
+	maxProbes     int         // probes before a table grows early; 0 is defaultMaxProbes
+	lineStats     bool        // report the lengths of station names and lines
+	delimiter     []byte      // field separator if not ';', for -delimiter-str
+	squeezeDelim  bool        // a run of delimiters separates the fields as one
+
+	// checkpoint, if set, is where the aggregate so far is saved every
+	// checkpointInterval; resume is a checkpoint to start from
+	checkpoint         string
+	checkpointInterval time.Duration
+	resume             string
+	prefault           bool
+	populate           bool
+	countOnly          bool
+	check              bool
+	strict             bool
+	comment            byte // 0 disables comment skipping
+
+	// recordSep, if hasRecordSep, ends records in place of '\n', for
+	// -record-sep
+	recordSep        byte
+	hasRecordSep     bool
+	verbose          bool
+	fahrenheit       bool     // input temperatures are Fahrenheit
+	parseUnit        bool     // a trailing C or F gives the line's unit, overriding fahrenheit
+	generalParse     bool     // -parse-mode strict: parse any digit count rather than the canonical format
+	sciNotation      bool     // like generalParse, also accepting exponents through strconv.ParseFloat
+	tenths           bool     // temperatures are whole numbers of tenths, for -tenths
+	offsets          bool     // record and print where each station first and last appears
+	keepEmpty        bool     // print seeded stations without rows
+	stats            bool     // skip malformed lines and report why, for -stats
+	leaderboard      bool     // order by count descending rather than by name
+	byRange          bool     // order by range descending rather than by name
+	showRange        bool     // also write each station's max - min
+	parallelPrefetch bool     // each worker issues MADV_WILLNEED for its block
+	dispatchBatch    int      // if set, workers draw batches of this many bytes from a queue
+	sortedInput      bool     // rows are grouped by station, for -sorted-input
+	verifySorted     bool     // fail if -sorted-input's input isn't sorted
+	writeBuf         int      // output buffer size; 0 uses bufio's default
+	flushEvery       int      // if set, flush the output after this many stations
+	minCount         uint64   // if set, leave out stations with fewer rows
+	meanBand         meanBand // if active, leave out stations with a mean outside it
+	decimalSep       string   // if set, replaces the decimal point in text output
+	withUnits        bool     // follow each temperature in text output with °C
+	meta             bool     // prepend a header describing the run
+	jsonOut          string   // if set, also write JSON results to this file, gzipped if it ends in .gz
+	splitDir         string   // if set, write one file per first byte of the station names here
+
+	// failFast stops at the first malformed line with an error; failure is
+	// where the workers of the range being processed record it
+	failFast bool
+	failure  *firstFailure
+
+	// throttle, if set, paces the workers to a maximum read rate
+	throttle *throttle
+
+	// partials, if set, reports each worker's results as it finishes
+	partials *partialStream
+
+	// trace, if set, reports each worker's stats for one station
+	trace *stationTrace
+
+	// manifest, if set, is filled in with a description of the run for
+	// -manifest-out
+	manifest *runManifest
+
+	// source is the input file's name, for -meta
+	source string
+
+	// reverseFields parses temperature;station rather than station;temperature
+	reverseFields bool
+
+	// windowSize, if set, maps and processes the file this many bytes at a
+	// time rather than all at once
+	windowSize int64
+
+	// encoding is the input's encoding for -encoding, utf16le or utf16be,
+	// or empty for UTF-8
+	encoding string
+
+	// tar reads the input as a tar archive of measurement files, for -tar,
+	// aggregating its regular members whose names end in tarExt
+	tar    bool
+	tarExt string
+
+	// normalizeNewlines strips the \r of each \r\n from streamed input,
+	// for -normalize-newlines
+	normalizeNewlines bool
+
+	// renames maps station names to the name they're reported under
+	renames map[string]string
+
+	// seeds are stations every default hashtable starts with, for
+	// -seed-stations
+	seeds [][]byte
+
+	// excluded are stations whose rows are dropped, for -exclude
+	excluded *stationSet
+
+	// maxStations, if set, fails the run once it has more distinct
+	// stations than the limit, for -max-stations
+	maxStations *stationLimit
+
+	// accumulator creates the per-worker and merged accumulators; nil uses
+	// the default min/mean/max hashtable
+	accumulator func(numBuckets uint64) Accumulator
+
+	// collect, if set, receives the final table in place of writing it,
+	// for -serve. Unless owned, the table's keys alias the mapped input and
+	// are only valid during the call.
+	collect func(res *hashtable, owned bool)
+
+	// heapReadBelow, if set, reads files smaller than this many bytes into
+	// the heap rather than mapping them, so the result's keys own their
+	// bytes
+	heapReadBelow int64
+
+	// ownedKeys is set once the keys no longer alias a mapping: the input
+	// was read into the heap, or each window's keys were copied
+	ownedKeys bool
+
+	// diag receives warnings and diagnostics; nil discards them
+	diag io.Writer
+}
+
+// newAccumulator returns the accumulator constructor selected by opts.
+func (opts options) newAccumulator() func(numBuckets uint64) Accumulator {
+	if opts.accumulator != nil {
+		return opts.accumulator
+	}
+	if opts.sortedInput {
+		return func(uint64) Accumulator { return newSortedRuns(opts.verifySorted) }
+	}
+	return func(numBuckets uint64) Accumulator {
+		// Leave room for the seeds to stay at most half the table
+		if n := 2 * uint64(len(opts.seeds)); numBuckets < n {
+			numBuckets = n
+		}
+		ht := NewHashTable(numBuckets)
+		ht.histograms = opts.iqr
+		ht.times = opts.withTime
+		if opts.maxProbes > 0 {
+			ht.maxProbes = opts.maxProbes
+		}
+		if opts.hotCache {
+			ht.hot = new([hotCacheSize]item)
+		}
+		ht.seed(opts.seeds)
+		return ht
+	}
+}
+
+// eol returns the byte that ends each record.
+func (opts *options) eol() byte {
+	if opts.hasRecordSep {
+		return opts.recordSep
+	}
+	return '\n'
+}
+
+// recordsRows reports whether rows go through RowRecorder.UpdateRow
+// rather than Accumulator.Update.
+func (opts options) recordsRows() bool {
+	return opts.offsets || opts.keepExtremes || opts.iqr || opts.wideSum || opts.withTime
+}
+
+// order returns the output order selected by opts.
+func (opts options) order() stationLess {
+	switch {
+	case opts.leaderboard:
+		return byCountDesc
+	case opts.byRange:
+		return byRangeDesc
+	}
+	return byName
+}
+
+// logf writes a diagnostic line under -verbose.
+func (opts *options) logf(format string, args ...any) {
+	if opts.verbose && opts.diag != nil {
+		fmt.Fprintf(opts.diag, format+"\n", args...)
+	}
+}
+
+// formatter returns the output formatter selected by opts, defaulting to
+// the reference text format.
+func (opts options) formatter() formatter {
+	if opts.offsets {
+		return offsetsFormat{}
+	}
+	f, ok := formatters[opts.format]
+	if !ok {
+		f = textFormat{}
+	}
+	switch f.(type) {
+	case textFormat:
+		f := textFormat{decimal: opts.decimalSep, showRange: opts.showRange}
+		if opts.withUnits {
+			f.unit = celsiusUnit
+		}
+		return f
+	case jsonFormat:
+		return jsonFormat{showRange: opts.showRange}
+	case ndjsonFormat:
+		return ndjsonFormat{showRange: opts.showRange}
+	case jsonArrayFormat:
+		return jsonArrayFormat{showRange: opts.showRange}
+	}
+	return f
+}
+
+// tempValidator returns the parser -strict and -check validate
+// temperatures with, which accepts the same format -parse-mode does.
+func (opts options) tempValidator() func([]byte) (int32, bool) {
+	if opts.tenths {
+		return parseTempTenths
+	}
+	if opts.sciNotation {
+		return parseTempSci
+	}
+	if opts.generalParse {
+		return parseTempGeneral
+	}
+	return parseTemp
+}
+
+func main() {
+	flag.Parse()
+	// Before anything starts goroutines, so the whole run sees the limit
+	if *gomaxprocs != 0 {
+		if *gomaxprocs < 0 {
+			log.Fatalf("-gomaxprocs must be a positive number, got %d", *gomaxprocs)
+		}
+		runtime.GOMAXPROCS(*gomaxprocs)
+	}
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+	if *blockprofile != "" {
+		runtime.SetBlockProfileRate(1)
+		defer writeProfile("block", *blockprofile)
+	}
+	if *mutexprofile != "" {
+		runtime.SetMutexProfileFraction(1)
+		defer writeProfile("mutex", *mutexprofile)
+	}
+
+	args := flag.Args()
+	argsOK := len(args) == 1
+	switch {
+	case *perFile && *compare:
+		log.Fatal("-per-file and -compare can't be combined")
+	case *perFile:
+		argsOK = len(args) > 0
+	case *compare:
+		argsOK = len(args) == 2
+	}
+	if !argsOK {
+		log.Fatal("Usage: 1brc <File>, 1brc -per-file <File or directory>..., or 1brc -compare <File> <File>")
+	}
+
+	fileName := args[0]
+
+	opts := options{
+		lowMem:             *lowMem,
+		format:             *format,
+		trimKeys:           *trimKeys,
+		collapseSpace:      *collapse,
+		gzipOut:            *gzipOut,
+		checkUTF8:          *checkUTF8,
+		keepExtremes:       *keepExtremes,
+		iqr:                *iqr,
+		failFast:           *failFast,
+		wideSum:            *wideSum,
+		hotCache:           *hotCache,
+		lineStats:          *lineLens,
+		checkpoint:         *checkpoint,
+		resume:             *resume,
+		checkpointInterval: time.Duration(*ckptInterval) * time.Second,
+		reverseFields:      *reverse,
+		prefault:           *prefault,
+		populate:           *populate,
+		countOnly:          *countOnly,
+		check:              *check,
+		strict:             *strict,
+		verbose:            *verbose,
+		writeBuf:           *writeBuf,
+		meta:               *meta,
+		jsonOut:            *jsonOut,
+		offsets:            *offsets,
+		keepEmpty:          *keepEmpty,
+		stats:              *skipStats,
+		leaderboard:        *leaderboard,
+		parallelPrefetch:   *parPrefetch,
+		dispatchBatch:      *dispatch,
+		diag:               os.Stderr,
+	}
+	if *workers == "auto" {
+		opts.autoWorkers = true
+		opts.workers = runtime.NumCPU()
+	} else {
+		n, err := strconv.Atoi(*workers)
+		if err != nil || n < 1 {
+			log.Fatalf("-workers must be a positive number or auto, got %q", *workers)
+		}
+		opts.workers = n
+	}
+	if *gomaxprocs != 0 {
+		// Workers beyond GOMAXPROCS share Ps rather than running in parallel
+		opts.logf("GOMAXPROCS %d with %d workers", runtime.GOMAXPROCS(0), opts.workers)
+	}
+	switch *unit {
+	case "c", "C":
+	case "f", "F":
+		opts.fahrenheit = true
+	default:
+		log.Fatalf("-unit must be c or f, got %q", *unit)
+	}
+	opts.parseUnit = *parseUnit
+	if *maxStations < 0 {
+		log.Fatalf("-max-stations must not be negative, got %d", *maxStations)
+	}
+	if *maxStations > 0 {
+		opts.maxStations = &stationLimit{max: *maxStations}
+	}
+	switch *parseMode {
+	case "fast":
+	case "strict":
+		opts.generalParse = true
+	default:
+		log.Fatalf("-parse-mode must be fast or strict, got %q", *parseMode)
+	}
+	if *sciNotation {
+		opts.sciNotation, opts.generalParse = true, true
+	}
+	if *tenths {
+		if *sciNotation {
+			log.Fatal("-tenths and -sci-notation can't be combined")
+		}
+		opts.tenths = true
+	}
+	if *comment != "" {
+		if len(*comment) != 1 {
+			log.Fatalf("-comment must be a single byte, got %q", *comment)
+		}
+		opts.comment = (*comment)[0]
+	}
+	if _, ok := formatters[opts.format]; !ok {
+		log.Fatalf("unknown -format %q", opts.format)
+	}
+	if opts.offsets && opts.format != "text" {
+		// -offsets writes its own listing in place of the stats
+		log.Fatalf("-offsets conflicts with -format=%s", opts.format)
+	}
+	if *recordSep != "" {
+		sep, err := parseByteFlag(*recordSep)
+		if err != nil {
+			log.Fatalf("-record-sep: %v", err)
+		}
+		opts.recordSep, opts.hasRecordSep = sep, true
+	}
+	if *delimStr != "" && *delimStr != ";" {
+		if strings.IndexByte(*delimStr, opts.eol()) >= 0 {
+			log.Fatal("-delimiter-str can't contain the record separator")
+		}
+		opts.delimiter = []byte(*delimStr)
+	} else if opts.eol() == ';' {
+		log.Fatal("-record-sep must differ from the field delimiter")
+	}
+	opts.squeezeDelim = *squeeze
+	if *clamp != "" {
+		c, err := parseClamp(*clamp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.clamp = &c
+	}
+	if *groupBy != "" {
+		n, err := parseGroupBy(*groupBy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.groupPrefix = n
+	}
+	if *fixed != "" {
+		layout, err := parseFixedLayout(*fixed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.fixed = &layout
+		if opts.check {
+			log.Fatal("-check does not support -fixed")
+		}
+		if opts.reverseFields {
+			log.Fatal("-reverse-fields does not apply to -fixed")
+		}
+		if opts.delimiter != nil {
+			log.Fatal("-delimiter-str does not apply to -fixed")
+		}
+	}
+
+	if opts.iqr && (opts.lowMem || opts.checkpoint != "" || opts.resume != "") {
+		log.Fatal("-iqr does not apply to -low-mem, -checkpoint or -resume")
+	}
+
+	opts.withTime = *withTime
+	switch *timeFormat {
+	case "rfc3339":
+	case "epoch":
+		opts.epochTime = true
+	default:
+		log.Fatalf("unknown -time-format %q", *timeFormat)
+	}
+	if opts.withTime && (opts.lowMem || opts.checkpoint != "" || opts.resume != "" || opts.fixed != nil || opts.reverseFields) {
+		log.Fatal("-with-time does not apply to -low-mem, -checkpoint, -resume, -fixed or -reverse-fields")
+	}
+	if (opts.checkpoint != "" || opts.resume != "") && (opts.countOnly || opts.check) {
+		log.Fatal("-checkpoint and -resume do not apply to -count-only or -check")
+	}
+
+	if *hashPrefixN < 0 {
+		log.Fatalf("-hash-prefix must not be negative, got %d", *hashPrefixN)
+	}
+	hashPrefix = *hashPrefixN
+	hashSeed = *hashSeedN
+	switch *probe {
+	case "linear":
+	case "double":
+		doubleHash = true
+	default:
+		log.Fatalf("-probe must be linear or double, got %q", *probe)
+	}
+	if *maxProbesN <= 0 {
+		log.Fatalf("-max-probes must be positive, got %d", *maxProbesN)
+	}
+	opts.maxProbes = *maxProbesN
+
+	if *flushEvery < 0 {
+		log.Fatalf("-flush-every must not be negative, got %d", *flushEvery)
+	}
+	opts.flushEvery = *flushEvery
+	opts.minCount = *minCount
+	if *meanBelow != "" {
+		below, err := parseMeanBound(*meanBelow)
+		if err != nil {
+			log.Fatalf("-mean-below: %v", err)
+		}
+		opts.meanBand.below, opts.meanBand.hasBelow = below, true
+	}
+	if *meanAbove != "" {
+		above, err := parseMeanBound(*meanAbove)
+		if err != nil {
+			log.Fatalf("-mean-above: %v", err)
+		}
+		opts.meanBand.above, opts.meanBand.hasAbove = above, true
+	}
+	opts.decimalSep = *decimalSep
+	opts.withUnits = *withUnits
+	opts.showRange = *showRange
+	switch *orderBy {
+	case "name":
+	case "count":
+		opts.leaderboard = true
+	case "range":
+		if opts.leaderboard {
+			log.Fatal("-order-by=range conflicts with -leaderboard")
+		}
+		opts.byRange = true
+	default:
+		log.Fatalf("unknown -order-by %q", *orderBy)
+	}
+	opts.splitDir = *splitOut
+	if opts.splitDir != "" && (opts.lowMem || opts.jsonOut != "") {
+		log.Fatal("-split-output does not apply to -low-mem or -json-out")
+	}
+
+	if *samples < 0 {
+		log.Fatalf("-samples must not be negative, got %d", *samples)
+	}
+	if k := *samples; k > 0 {
+		opts.accumulator = func(uint64) Accumulator { return newSampleAccumulator(k) }
+	}
+
+	switch *encoding {
+	case "utf8":
+	case "utf16le", "utf16be":
+		opts.encoding = *encoding
+	default:
+		log.Fatalf("unknown -encoding %q", *encoding)
+	}
+	opts.tar, opts.tarExt = *tarIn, *tarExt
+	opts.normalizeNewlines = *normalizeNL
+
+	if *windowMB < 0 {
+		log.Fatalf("-window must not be negative, got %d", *windowMB)
+	}
+	opts.windowSize = int64(*windowMB) << 20
+
+	if *readBelowMB < 0 {
+		log.Fatalf("-read-below must not be negative, got %d", *readBelowMB)
+	}
+	opts.heapReadBelow = int64(*readBelowMB) << 20
+
+	if *dispatch < 0 {
+		log.Fatalf("-dispatch-batch must not be negative, got %d", *dispatch)
+	}
+	if *dispatch > 0 && opts.autoWorkers {
+		log.Fatal("-dispatch-batch does not apply to -workers=auto")
+	}
+
+	if *maxMBps > 0 {
+		opts.throttle = newThrottle(*maxMBps * (1 << 20))
+	}
+	if *rename != "" {
+		renames, err := loadRenames(*rename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.renames = renames
+	}
+	if *seedStations != "" {
+		seeds, err := loadSeedStations(*seedStations)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.seeds = seeds
+	}
+	if *exclude != "" {
+		excluded, err := excludedNames(*exclude)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.excluded = excluded
+		opts.seeds = withoutExcluded(opts.seeds, excluded)
+	}
+
+	opts.sortedInput = *sortedIn || *verifySorted
+	opts.verifySorted = *verifySorted
+	if opts.sortedInput {
+		// The runs only record min, max, sum and count, and rely on the
+		// workers' blocks being merged in file order
+		if opts.recordsRows() || opts.seeds != nil || opts.accumulator != nil || opts.dispatchBatch > 0 {
+			log.Fatal("-sorted-input does not apply to -offsets, -keep-extremes, -iqr, -wide-sum, -with-time, -seed-stations, -samples or -dispatch-batch")
+		}
+	}
+
+	if *quiet {
+		// Everything but the result and the final error goes through diag
+		opts.verbose = false
+		opts.diag = nil
+	}
+	if *streamParts {
+		opts.partials = newPartialStream(opts.diag)
+	}
+	if *traceName != "" {
+		opts.trace = newStationTrace(opts.diag, *traceName)
+	}
+
+	if *perFile {
+		if *manifestOut != "" {
+			log.Fatal("-per-file does not support -manifest-out")
+		}
+		if err := processPerFile(os.Stdout, args, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *compare {
+		if opts.countOnly || opts.check || *samples > 0 {
+			log.Fatal("-compare does not support -count-only, -check or -samples")
+		}
+		if err := compareFiles(os.Stdout, args[0], args[1], opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *selftest > 0 {
+		report := io.Writer(os.Stderr)
+		if *quiet {
+			report = io.Discard
+		}
+		if err := selfTest(report, fileName, *selftest, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *sweepFlag {
+		if opts.countOnly || opts.check || opts.encoding != "" || opts.tar {
+			log.Fatal("-sweep does not support -count-only, -check, -encoding or -tar")
+		}
+		if err := sweep(os.Stdout, fileName, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *followFlag {
+		if opts.countOnly || opts.check || opts.strict || opts.encoding != "" || opts.tar || opts.normalizeNewlines {
+			log.Fatal("-follow does not support -count-only, -check, -strict, -encoding, -tar or -normalize-newlines")
+		}
+		if *followEvery <= 0 {
+			log.Fatalf("-follow-interval must be positive, got %d", *followEvery)
+		}
+		if err := follow(os.Stdout, fileName, opts, time.Duration(*followEvery)*time.Second, nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *replFlag {
+		if opts.countOnly || opts.check || *samples > 0 {
+			log.Fatal("-repl does not support -count-only, -check or -samples")
+		}
+		if err := repl(os.Stdin, os.Stdout, fileName, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *serveAddr != "" {
+		if opts.countOnly || opts.check || *samples > 0 {
+			log.Fatal("-serve does not support -count-only, -check or -samples")
+		}
+		if err := serve(*serveAddr, fileName, opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *manifestOut != "" {
+		opts.manifest = &runManifest{input: fileName}
+	}
+	began := time.Now()
+	err := process(os.Stdout, fileName, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.manifest != nil {
+		opts.manifest.elapsed = time.Since(began)
+		if err := writeManifest(*manifestOut, opts.manifest); err != nil {
+			log.Fatalf("cannot write -manifest-out: %v", err)
+		}
+	}
+}
+
+// parseByteFlag parses a flag naming a single byte, either literally or as
+// a Go escape such as \x00 or \t.
+func parseByteFlag(s string) (byte, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	v, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+	if err != nil || multibyte || tail != "" || v > 0xff {
+		return 0, fmt.Errorf("%q is not a single byte", s)
+	}
+	return byte(v), nil
+}
+
+// writeProfile writes the named runtime/pprof profile to fileName.
+func writeProfile(name, fileName string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// selfTest runs the aggregation passes times, cycling the worker count
+// between 1 and twice the configured value, and returns an error if any
+// pass produces output that differs from the first.
+func selfTest(report io.Writer, fileName string, passes int, opts options) error {
+	maxWorkers := 2 * opts.workers
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+
+	var reference []byte
+	for i := 0; i < passes; i++ {
+		passOpts := opts
+		if i > 0 {
+			passOpts.workers = 1 + (opts.workers+i-1)%maxWorkers
+		}
+
+		var out bytes.Buffer
+		if err := process(&out, fileName, passOpts); err != nil {
+			return err
+		}
+
+		if i == 0 {
+			reference = out.Bytes()
+			fmt.Fprintf(report, "selftest: pass 1 (workers=%d) produced %d bytes\n", passOpts.workers, len(reference))
+			continue
+		}
+		if !bytes.Equal(reference, out.Bytes()) {
+			return fmt.Errorf("selftest: pass %d (workers=%d) diverged from pass 1", i+1, passOpts.workers)
+		}
+		fmt.Fprintf(report, "selftest: pass %d (workers=%d) matches\n", i+1, passOpts.workers)
+	}
+	fmt.Fprintf(report, "selftest: %d passes stable\n", passes)
+	return nil
+}
+
+// now is the clock used for -meta timestamps.
+var now = time.Now
+
+func process(output io.Writer, fileName string, opts options) (err error) {
+	opts.source = fileName
+
+	if opts.gzipOut {
+		gz := gzip.NewWriter(output)
+		defer func() {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		output = gz
+	}
+
+	file, err := openMeasurements(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat measurements file %q: %w", fileName, err)
+	}
+
+	size := stat.Size()
+	if !stat.Mode().IsRegular() {
+		size = -1
+	}
+
+	if opts.tar {
+		opts.logf("reading %s as a tar archive", fileName)
+		opts.manifest.scanned(size, "tar")
+		return processTar(output, file, &opts)
+	}
+
+	// UTF-16 has to be decoded in order, so it's never mapped
+	if opts.encoding != "" {
+		opts.logf("decoding %s from %s, streaming it", fileName, opts.encoding)
+		opts.manifest.scanned(size, "utf16-stream")
+		return processStream(output, newUTF16Reader(file, opts.encoding == "utf16be"), &opts)
+	}
+
+	// A FIFO or other special file reports no size and can't be mapped
+	if !stat.Mode().IsRegular() {
+		opts.logf("%s is not a regular file, streaming it", fileName)
+		opts.manifest.scanned(size, "stream")
+		return processStream(output, file, &opts)
+	}
+
+	if opts.normalizeNewlines {
+		return fmt.Errorf("-normalize-newlines only applies to streamed input, but %s is a regular file, which is parsed in place; pipe it in, as through /dev/stdin", fileName)
+	}
+
+	// Checkpoints are taken between windows
+	if opts.windowSize > 0 || stat.Size() > maxMappingSize() || opts.checkpoint != "" || opts.resume != "" {
+		opts.manifest.scanned(size, "windowed")
+		return processWindowed(output, file, stat.Size(), &opts)
+	}
+
+	var data []byte
+	if stat.Size() < opts.heapReadBelow {
+		// Keys aliasing a heap buffer keep it alive, so they can outlive
+		// the call without being copied
+		opts.logf("reading %d bytes into memory rather than mapping them", stat.Size())
+		data = make([]byte, stat.Size())
+		if _, err := io.ReadFull(file, data); err != nil {
+			return fmt.Errorf("cannot read measurements file %q: %w", fileName, err)
+		}
+		opts.ownedKeys = true
+		opts.manifest.scanned(size, "heap")
+	} else {
+		opts.manifest.scanned(size, "mmap")
+		data, err = mapFile(file, 0, int(stat.Size()), &opts)
+		if err != nil {
+			if err == syscall.ENOMEM && strconv.IntSize == 32 {
+				opts.logf("mapping %d bytes failed, falling back to windows", stat.Size())
+				opts.manifest.scanned(size, "windowed")
+				return processWindowed(output, file, stat.Size(), &opts)
+			}
+			return fmt.Errorf("cannot map measurements file %q: %w", fileName, err)
+		}
+		defer syscall.Munmap(data)
+	}
+
+	start := bomLen(data)
+	if opts.countOnly {
+		rows := countRows(data[start:], opts.workers, opts.eol())
+		opts.manifest.countedRows(rows, opts.workers)
+		_, err := fmt.Fprintln(output, rows)
+		return err
+	}
+
+	if opts.check {
+		return checkFile(output, data, start, &opts)
+	}
+
+	if opts.failFast {
+		opts.failure = newFirstFailure()
+	}
+	results := processRange(data, start, len(data), &opts)
+
+	// The workers have all returned, so none still reads the mapping
+	if err := opts.failure.err(0); err != nil {
+		return err
+	}
+	if err := opts.maxStations.err(); err != nil {
+		return err
+	}
+	if opts.stats {
+		reportSkips(opts.diag, totalSkips(results))
+		reportClamped(opts.diag, totalClamped(results), opts.clamp)
+	}
+	if opts.lineStats {
+		reportLineStats(opts.diag, totalLineStats(results))
+	}
+	if opts.strict {
+		if err := reportRejected(opts.diag, results); err != nil {
+			return err
+		}
+	}
+
+	// Size chosen to keep load factor <2 for ~413k unique stations
+	// 2^18 = 262,144 buckets → load factor ~1.6
+	merged := mergeAccumulators(results, opts.newAccumulator()(1<<18))
+
+	// The worker tables are no longer needed once merged
+	results = nil
+	return writeOutput(output, merged, &opts)
 }
 
-var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+// utf8BOM is the byte order mark some Windows tools start UTF-8 files with
+const utf8BOM = "\xef\xbb\xbf"
 
-func main() {
-	flag.Parse()
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+// bomLen returns the length of the UTF-8 byte order mark data starts with,
+// or 0 if it doesn't, so the mark isn't read as part of the first station.
+func bomLen(data []byte) int {
+	if bytes.HasPrefix(data, []byte(utf8BOM)) {
+		return len(utf8BOM)
 	}
+	return 0
+}
 
-	args := flag.Args()
-	if len(args) != 1 {
-		log.Fatal("Usage: 1brc <File>")
+// openMeasurements opens fileName for reading. Errors name the file once,
+// rather than repeating the path as *fs.PathError would, and a permission
+// error says what's needed to fix it.
+func openMeasurements(fileName string) (*os.File, error) {
+	file, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+	if err == nil {
+		return file, nil
 	}
 
-	fileName := args[0]
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return nil, fmt.Errorf("cannot open measurements file %q: %w (it must be readable by the current user)", fileName, err)
+	}
+	return nil, fmt.Errorf("cannot open measurements file %q: %w", fileName, err)
+}
+
+// mmap is syscall.Mmap, replaced in tests to simulate a filesystem
+// without private mappings.
+var mmap = syscall.Mmap
+
+// mapFile maps length bytes of file from offset, which must be page
+// aligned, and advises the kernel of the access pattern.
+func mapFile(file *os.File, offset int64, length int, opts *options) ([]byte, error) {
+	flags := syscall.MAP_PRIVATE
+	// MAP_POPULATE is opt-in as it doesn't pay for itself: the faults it
+	// saves on a warm cache cost more up front, and on a cold one the reads
+	// are the same either way (see BenchmarkProcessPopulate)
+	if opts.populate {
+		flags |= mapPopulate
+	}
 
-	err := process(os.Stdout, fileName)
+	data, err := mmap(int(file.Fd()), offset, length, syscall.PROT_READ, flags)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENODEV) {
+		// Some filesystems only support shared mappings. Nothing writes to
+		// the mapping, so it makes no difference to the result.
+		opts.logf("private mapping failed (%v), retrying shared", err)
+		flags = flags&^syscall.MAP_PRIVATE | syscall.MAP_SHARED
+		data, err = mmap(int(file.Fd()), offset, length, syscall.PROT_READ, flags)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	// Advise the kernel about our sequential access pattern
+	adviseSequential(data)
+
+	if opts.prefault {
+		prefaultPages(data)
 	}
+	return data, nil
 }
 
-func process(output io.Writer, fileName string) error {
-	file, err := os.OpenFile(fileName, os.O_RDONLY, 0)
-	if err != nil {
+// writeOutput sorts and writes the merged aggregate.
+func writeOutput(output io.Writer, merged Accumulator, opts *options) error {
+	if runs, ok := merged.(*sortedRuns); ok {
+		if runs.err != nil {
+			return runs.err
+		}
+		merged = runs.table()
+	}
+
+	res, ok := merged.(*hashtable)
+	if !ok {
+		// Custom accumulators are responsible for their own output
+		w, ok := merged.(io.WriterTo)
+		if !ok {
+			return fmt.Errorf("accumulator %T has no output", merged)
+		}
+		_, err := w.WriteTo(output)
 		return err
 	}
-	defer file.Close()
 
-	stat, err := file.Stat()
-	if err != nil {
+	if err := opts.maxStations.check(res); err != nil {
 		return err
 	}
+	if opts.strict && opts.seeds != nil {
+		if err := reportUnexpected(opts.diag, res, opts.seeds); err != nil {
+			return err
+		}
+	}
+	if opts.checkUTF8 {
+		if err := reportInvalidUTF8(opts.diag, res, opts.strict); err != nil {
+			return err
+		}
+	}
+
+	if opts.renames != nil {
+		res = applyRenames(res, opts.renames)
+	}
+	if opts.seeds != nil {
+		res = settleSeeds(res, opts.keepEmpty)
+	}
+	opts.manifest.counted(res, opts.workers)
+	if opts.collect != nil {
+		opts.collect(res, opts.ownedKeys)
+		return nil
+	}
+
+	var meta *runMeta
+	if opts.meta {
+		meta = &runMeta{source: opts.source, generated: now()}
+		for _, item := range res.items {
+			if item.value != nil {
+				meta.rows += item.value.count
+			}
+		}
+	}
+
+	if opts.minCount > 0 {
+		var dropped int
+		res, dropped = dropRare(res, opts.minCount)
+		if opts.stats {
+			reportDropped(opts.diag, dropped, opts.minCount)
+		}
+	}
+	if opts.meanBand.active() {
+		var dropped int
+		res, dropped = filterMean(res, opts.meanBand)
+		if opts.stats {
+			reportMeanDropped(opts.diag, dropped)
+		}
+	}
+
+	if opts.splitDir != "" {
+		return writeSplit(opts.splitDir, res, opts, meta)
+	}
+
+	sinks := []sink{{output, opts.formatter()}}
+	if opts.jsonOut == "" {
+		return writeSinks(sinks, res, opts, meta)
+	}
 
-	data, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE|syscall.MAP_POPULATE)
+	f, err := createOutputFile(opts.jsonOut)
 	if err != nil {
 		return err
 	}
-	defer syscall.Munmap(data)
+	sinks = append(sinks, sink{f, jsonFormat{showRange: opts.showRange}})
+	if err := writeSinks(sinks, res, opts, meta); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	// Advise the kernel about our sequential access pattern
-	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+// writeSinks sorts res once and formats it to every sink.
+func writeSinks(sinks []sink, res *hashtable, opts *options, meta *runMeta) error {
+	if opts.lowMem {
+		return writeLowMem(sinks, res, opts.order(), opts.writeBuf, opts.flushEvery, meta)
+	}
+
+	populated := populatedItems(res)
+
+	// Sort only the populated items
+	sortItemsBy(populated, opts.order())
+
+	return writeResults(sinks, populated, opts.writeBuf, opts.flushEvery, meta)
+}
+
+// runWorkers processes each block on its own goroutine and returns their
+// results in block order.
+func runWorkers(data []byte, blocks []block, opts *options) []*chunkResult {
+	results := make([]*chunkResult, len(blocks))
 
 	var wg sync.WaitGroup
-	numWorkers := runtime.NumCPU()
-	chunkSize := len(data) / numWorkers
+	wg.Add(len(blocks))
+	for i, blk := range blocks {
+		go func(i, blockStart, blockEnd int) {
+			defer wg.Done()
+			defer func() {
+				opts.partials.report(i, results[i])
+				opts.trace.report(i, &block{blockStart, blockEnd}, results[i])
+			}()
+			// Per-worker table sized for ~34k stations (413k total / 12 CPUs)
+			// 2^14 = 16,384 buckets → load factor ~2.0
+			acc := opts.newAccumulator()(1 << 14)
+			opts.maxStations.watch(acc)
+			if opts.throttle != nil {
+				results[i] = processThrottled(data, blockStart, blockEnd, opts, acc)
+				return
+			}
+			if opts.parallelPrefetch {
+				adviseBlock(data, blockStart, blockEnd)
+			}
+			results[i] = processBlock(data, blockStart, blockEnd, opts, acc)
+		}(i, blk.start, blk.end)
+	}
 
-	results := make([]*hashtable, numWorkers)
+	wg.Wait()
+	return results
+}
+
+// processBlock aggregates one block with the parser opts selects.
+func processBlock(data []byte, start, end int, opts *options, acc Accumulator) *chunkResult {
+	checkBlockStart(data, start, opts.eol())
+	if opts.fixed != nil {
+		return processFixedData(data, start, end, *opts.fixed, opts, acc)
+	}
+	return processData(data, start, end, opts, acc)
+}
+
+// block is a newline-aligned range of the input handled by one worker.
+type block struct {
+	start, end int
+}
+
+// splitBlocks divides data[start:end], which must begin at the start of a
+// line, into numWorkers blocks of roughly equal size, each ending just after
+// an eol byte, normally a newline (or at end).
+func splitBlocks(data []byte, start, end, numWorkers int, eol byte) []block {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	chunkSize := (end - start) / numWorkers
+
+	blocks := make([]block, numWorkers)
 
-	blockStart := 0
-	wg.Add(numWorkers)
+	blockStart := start
 	for i := 0; i < numWorkers; i++ {
 
+		// A long line in an earlier block can push blockStart past where this
+		// block would naturally end, so never let blockEnd run off the data
 		blockEnd := blockStart + chunkSize
+		if blockEnd > end {
+			blockEnd = end
+		}
+		// End just after the first eol at or past the natural end, so a
+		// line ending exactly there stays in this block, or at end if
+		// there's none: the rest is the last line, unterminated
 		if i == numWorkers-1 {
-			blockEnd = len(data)
+			blockEnd = end
+		} else if nl := bytes.IndexByte(data[blockEnd:end], eol); nl >= 0 {
+			blockEnd += nl + 1
 		} else {
-			for blockEnd < len(data)-1 && data[blockEnd] != '\n' {
-				blockEnd++
-			}
-			if blockEnd < len(data) {
-				blockEnd++
-			}
+			blockEnd = end
 		}
 
-		go func(i, blockStart, blockEnd int) {
-			defer wg.Done()
-			results[i] = processData(data, blockStart, blockEnd)
-		}(i, blockStart, blockEnd)
+		blocks[i] = block{blockStart, blockEnd}
 		blockStart = blockEnd
 	}
+	return blocks
+}
 
-	wg.Wait()
-
-	res := mergeHashTables(results)
+// aggregateItems processes fileName with opts and returns a copy of its
+// stations, sorted by name, that stays valid once the input is unmapped.
+func aggregateItems(fileName string, opts options) ([]item, error) {
+	if opts.accumulator != nil {
+		// A custom accumulator writes its own output and never has the
+		// stations to collect
+		return nil, fmt.Errorf("custom accumulators have no stations to collect")
+	}
+	var items []item
+	opts.collect = func(res *hashtable, owned bool) {
+		items = populatedItems(res)
+		for i := range items {
+			v := *items[i].value
+			items[i].value = &v
+			if !owned {
+				items[i].key = bytes.Clone(items[i].key)
+			}
+		}
+		sortItems(items)
+	}
+	if err := process(io.Discard, fileName, opts); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
 
-	// Create slice of just the populated items
-	populated := make([]item, 0, res.size)
-	for _, item := range res.items {
+// populatedItems returns a slice of just the populated items of ht.
+func populatedItems(ht *hashtable) []item {
+	populated := make([]item, 0, ht.size)
+	for _, item := range ht.items {
 		if item.value != nil {
 			populated = append(populated, item)
 		}
 	}
+	return populated
+}
 
-	// Sort only the populated items
-	sort.Slice(populated, func(i, j int) bool {
-		return bytes.Compare(populated[i].key, populated[j].key) < 0
+func sortItems(items []item) {
+	sortItemsBy(items, byName)
+}
+
+func sortItemsBy(items []item, less stationLess) {
+	sort.Slice(items, func(i, j int) bool {
+		return less(items[i].key, items[i].value, items[j].key, items[j].value)
 	})
+}
 
-	b := bufio.NewWriter(output)
+// stationLess reports whether station a is output before station b.
+type stationLess func(aKey []byte, a *stats, bKey []byte, b *stats) bool
 
-	const div10 = 0.1
-	b.WriteByte('{')
-	for i, item := range populated {
-		if i > 0 {
-			b.WriteString(", ")
-		}
-		stats := item.value
-		mean := float64(stats.sum) / float64(stats.count) * div10
+// byName is the reference output order.
+func byName(aKey []byte, _ *stats, bKey []byte, _ *stats) bool {
+	return bytes.Compare(aKey, bKey) < 0
+}
 
-		b.Write(item.key)
-		fmt.Fprintf(b, "=%.1f/%.1f/%.1f",
-			float64(stats.min)*div10,
-			mean,
-			float64(stats.max)*div10)
+// byCountDesc is the -leaderboard order: most rows first, ties broken by
+// name so the output stays deterministic.
+func byCountDesc(aKey []byte, a *stats, bKey []byte, b *stats) bool {
+	if a.count != b.count {
+		return a.count > b.count
 	}
-	b.WriteString("}\n")
+	return bytes.Compare(aKey, bKey) < 0
+}
 
-	b.Flush()
-	return nil
+// byRangeDesc is -order-by=range: widest range first, ties broken by
+// name.
+var byRangeDesc = descBy((*stats).rangeTenths)
+
+// adviseBlock asks the kernel to read ahead the pages of data[start:end],
+// for -parallel-prefetch. With every worker advising its own block the
+// reads are issued for all blocks at once, rather than following a single
+// sequential readahead, which can keep more of a fast device's queues busy.
+func adviseBlock(data []byte, start, end int) {
+	// madvise needs a page-aligned start; the mapping itself is aligned
+	pageSize := os.Getpagesize()
+	adviseWillNeed(data[start/pageSize*pageSize : end])
 }
 
-func mergeHashTables(tables []*hashtable) *hashtable {
-	// Size chosen to keep load factor <2 for ~413k unique stations
-	// 2^18 = 262,144 buckets → load factor ~1.6
-	res := NewHashTable(1 << 18)
+// prefaultSink keeps the compiler from discarding prefaultPages' reads.
+var prefaultSink byte
+
+// prefaultPages pages in the whole mapping before any worker starts, so a
+// benchmark of the parse measures steady-state throughput rather than the
+// cost of the first page faults. On a cold cache this moves the disk reads
+// out of the parse; on a warm cache it only costs the extra pass.
+//
+// It makes a whole run slower, not faster. With the page cache dropped
+// before each run, on a 1 CPU VM with 5GB of memory, 200M rows (3.3GB)
+// took 18.4-18.9s without it and 21.1-22.5s with it, since the pass can't
+// overlap the parse. 1B rows (16.4GB, more than memory) took 93-100s
+// without it and 109-119s with it: the first pages are evicted before the
+// workers get to them, so they're read twice.
+func prefaultPages(data []byte) {
+	adviseWillNeed(data)
+
+	pageSize := os.Getpagesize()
+	var sink byte
+	for i := 0; i < len(data); i += pageSize {
+		sink ^= data[i]
+	}
+	prefaultSink = sink
+}
+
+// chunkResult is what a worker produces for its block of the file.
+type chunkResult struct {
+	acc      Accumulator
+	rejected rejections
+	skipped  skipCounts
+	clamped  int // temperatures -clamp moved to a bound
+
+	// failure, under -fail-fast, is shared by the workers of the range
+	failure *firstFailure
+
+	// lengths are only collected under -line-stats
+	lengths lineStats
+}
+
+func processData(data []byte, start int, endPos int, opts *options, acc Accumulator) *chunkResult {
+	res := &chunkResult{acc: acc, failure: opts.failure}
+	if ht, ok := acc.(*hashtable); ok && opts.plainRecords() {
+		processPlain(data, start, endPos, ht)
+		return res
+	}
+
+	strict := opts.strict
+	// -stats validates like strict mode, but skips malformed lines rather
+	// than rejecting them, and -fail-fast stops at the first
+	failure := opts.failure
+	validating := strict || opts.stats || failure != nil
+	comment := opts.comment
+	eol := opts.eol()
+	reverse := opts.reverseFields
+	parser := newFieldParser(opts, validating)
+	var rec record
+	recorder, _ := acc.(RowRecorder)
+	if !opts.recordsRows() {
+		recorder = nil
+	}
+	keepExtremes := opts.keepExtremes
+	measure := opts.lineStats
+	// The multi-byte separator is only scanned for when configured, so the
+	// default stays on the single-byte loop
+	delim := opts.delimiter
+	delimLen := 1
+	if delim != nil {
+		delimLen = len(delim)
+	}
+	squeeze := opts.squeezeDelim
+	limit := opts.maxStations
+
+	i := start
+	for i < endPos {
+		if failure != nil && failure.passed(i) {
+			break
+		}
+		if limit.hit() {
+			break
+		}
+		if comment != 0 && data[i] == comment {
+			i = nextLine(data, i, endPos, eol)
+			continue
+		}
 
-	for _, table := range tables {
-		for _, item := range table.items {
-			if item.value == nil {
+		semicolonPos := i
+		if delim != nil {
+			// -delimiter-str: find the end of the line, then the
+			// separator within it
+			end := i + bytes.IndexByte(data[i:endPos], eol)
+			if end < i {
+				end = endPos
+			}
+			idx := bytes.Index(data[i:end], delim)
+			if idx < 0 {
+				if validating {
+					why := skipNoDelimiter
+					if end == i {
+						why = skipEmpty
+					}
+					res.skip(why, data, i, end, strict)
+				}
+				i = end + 1
 				continue
 			}
+			semicolonPos = i + idx
+		} else if validating {
+			// Never look for the delimiter past the end of the line
+			for ; semicolonPos < endPos && data[semicolonPos] != ';' && data[semicolonPos] != eol; semicolonPos++ {
+			}
+			if semicolonPos == endPos || data[semicolonPos] == eol {
+				why := skipNoDelimiter
+				if semicolonPos == i {
+					why = skipEmpty
+				}
+				res.skip(why, data, i, semicolonPos, strict)
+				i = semicolonPos + 1
+				continue
+			}
+		} else {
+			for ; semicolonPos < endPos && data[semicolonPos] != ';'; semicolonPos++ {
+			}
+			if semicolonPos == endPos {
+				break
+			}
+		}
 
-			s := res.get(item.hash, item.key)
-			if s == nil {
-				res.add(item.hash, item.key, &stats{
-					max:   item.value.max,
-					min:   item.value.min,
-					sum:   item.value.sum,
-					count: item.value.count,
-				})
-			} else {
-				s.min = min(s.min, item.value.min)
-				s.max = max(s.max, item.value.max)
-				s.sum += item.value.sum
-				s.count += item.value.count
+		lineEnd := semicolonPos + delimLen
+		for ; lineEnd < endPos; lineEnd++ {
+			if data[lineEnd] == eol {
+				break
 			}
+		}
 
+		afterDelim := semicolonPos + delimLen
+		if squeeze {
+			afterDelim = skipDelims(data, afterDelim, lineEnd, delim)
 		}
-	}
 
+		keyStart, keyEnd := i, semicolonPos
+		tempStart, tempEnd := afterDelim, lineEnd
+		if reverse {
+			keyStart, keyEnd = afterDelim, lineEnd
+			tempStart, tempEnd = i, semicolonPos
+		}
+
+		verdict, why := parser.parse(data[keyStart:keyEnd], data[tempStart:tempEnd], &rec)
+		if verdict != recordAccepted {
+			if verdict == recordMalformed {
+				res.skip(why, data, i, lineEnd, strict)
+			}
+			i = lineEnd + 1
+			continue
+		}
+		if rec.clamped {
+			res.clamped++
+		}
+
+		if recorder != nil {
+			var text []byte
+			if keepExtremes {
+				text = rec.text
+			}
+			recorder.UpdateRow(rec.key, rec.hash, rec.temp, int64(i), text, rec.at)
+		} else {
+			acc.Update(rec.key, rec.hash, rec.temp)
+		}
+		if measure {
+			res.lengths.add(len(rec.key), lineEnd-i)
+		}
+
+		// Move to next line
+		i = lineEnd + 1
+
+	}
 	return res
 }
 
-func processData(data []byte, start int, endPos int) *hashtable {
-	// Per-worker hash table sized for ~34k stations (413k total / 12 CPUs)
-	// 2^14 = 16,384 buckets → load factor ~2.0
-	res := NewHashTable(1 << 14)
+// plainRecords reports whether opts leaves every record as the challenge
+// has it, a station, a semicolon and a temperature with one decimal
+// ending in a newline, aggregated into min, max, sum and count alone, so
+// processData can use processPlain.
+func (opts *options) plainRecords() bool {
+	return !opts.strict && !opts.stats && opts.failure == nil && opts.comment == 0 && !opts.hasRecordSep &&
+		!opts.reverseFields && !opts.recordsRows() && !opts.lineStats && opts.maxStations == nil &&
+		opts.delimiter == nil && !opts.squeezeDelim && !opts.trimKeys && !opts.collapseSpace &&
+		opts.groupPrefix == 0 && opts.excluded == nil && !opts.parseUnit && !opts.fahrenheit &&
+		!opts.generalParse && !opts.sciNotation && !opts.tenths && opts.clamp == nil
+}
 
+// processPlain is processData's loop for input plainRecords allows, the
+// case the challenge is timed on. It updates ht directly and parses in
+// line, rather than through the fieldParser and the Accumulator interface,
+// and checks no option per line; BenchmarkProcessPlain guards it.
+func processPlain(data []byte, start, endPos int, ht *hashtable) {
+	// The station is hashed as it's scanned, unless only a prefix of it is
+	prefix := hashPrefix > 0
 	i := start
 	for i < endPos {
 		semicolonPos := i
+		hash := newFnvHash()
 		for ; semicolonPos < endPos && data[semicolonPos] != ';'; semicolonPos++ {
+			hash *= fnvPrime
+			hash ^= fnvHash(data[semicolonPos])
 		}
 		if semicolonPos == endPos {
 			break
 		}
-
-		hash := hashBytes(data, i, semicolonPos)
-
-		stationKey := data[i:semicolonPos]
+		if prefix {
+			hash = hashBytes(data, i, semicolonPos)
+		}
 		lineEnd := semicolonPos + 1
-		for ; lineEnd < endPos; lineEnd++ {
-			if data[lineEnd] == '\n' {
-				break
-			}
+		for ; lineEnd < endPos && data[lineEnd] != '\n'; lineEnd++ {
 		}
 
-		tempStart := semicolonPos + 1
-		tempBytes := data[tempStart:lineEnd]
-		temp := bytesToFixedPointInt(tempBytes)
+		value := data[semicolonPos+1 : lineEnd]
+		if len(value) == 0 || loneSign(value) {
+			i = lineEnd + 1
+			continue
+		}
+		key := data[i:semicolonPos]
+		temp := bytesToFixedPointInt(value)
 
-		s := res.get(hash, stationKey)
-		if s == nil {
-			// Create new stats entry
-			s = &stats{temp, temp, temp, 1}
-			res.add(hash, data[i:semicolonPos], s)
-		} else {
-			// Update existing stats
+		// A station found in its home slot, the usual case, is updated
+		// here; anything else takes get's probing and add
+		if home := &ht.items[hash%uint64(len(ht.items))]; home.value != nil && home.matches(hash, key) {
+			s := home.value
 			if temp < s.min {
 				s.min = temp
 			}
 			if temp > s.max {
 				s.max = temp
 			}
-			s.sum += temp
+			s.sum += int64(temp)
 			s.count++
+		} else {
+			ht.Update(key, hash, temp)
 		}
-
-		// Move to next line
 		i = lineEnd + 1
+	}
+}
 
+// nextLine returns the start of the line after the one containing i, where
+// lines end with eol.
+func nextLine(data []byte, i, endPos int, eol byte) int {
+	for ; i < endPos && data[i] != eol; i++ {
 	}
-	return res
+	return i + 1
+}
+
+// skipDelims returns the index of the first byte of data[i:end] past any
+// delimiters starting at i, for -squeeze-delim, with delim nil for ';'.
+func skipDelims(data []byte, i, end int, delim []byte) int {
+	if delim == nil {
+		for ; i < end && data[i] == ';'; i++ {
+		}
+		return i
+	}
+	for bytes.HasPrefix(data[i:end], delim) {
+		i += len(delim)
+	}
+	return i
+}
+
+// fahrenheitToCelsius converts tenths of a degree Fahrenheit to tenths of a
+// degree Celsius, rounding half up. (f-320)*5/9 is rounded in integers as
+// floor((10*(f-320) + 9) / 18) to stay exact.
+func fahrenheitToCelsius(f int32) int32 {
+	return int32(floorDiv(10*(int64(f)-320)+9, 18))
+}
+
+// splitUnit strips a trailing C or F from a temperature for -parse-unit,
+// reporting whether the line is in Fahrenheit: true for F, false for C,
+// and fahrenheit, the -unit default, for a temperature without one.
+func splitUnit(b []byte, fahrenheit bool) ([]byte, bool) {
+	if len(b) > 0 {
+		switch b[len(b)-1] {
+		case 'C':
+			return b[:len(b)-1], false
+		case 'F':
+			return b[:len(b)-1], true
+		}
+	}
+	return b, fahrenheit
+}
+
+func isASCIISpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// loneSign reports whether b is nothing but a minus sign, which
+// bytesToFixedPointInt would read past the end of.
+func loneSign(b []byte) bool {
+	return len(b) == 1 && b[0] == '-'
 }
 
+// bytesToFixedPointInt parses a temperature assuming the canonical 1BRC
+// format, one or two integer digits and exactly one decimal digit, without
+// checking. -parse-mode strict uses parseTempGeneral instead.
 func bytesToFixedPointInt(bytes []byte) int32 {
 	negative := bytes[0] == '-'
 	idx := 0
@@ -245,6 +1718,27 @@ func bytesToFixedPointInt(bytes []byte) int32 {
 	return val
 }
 
+// tenthsToFixedPointInt parses a temperature already in tenths, a signed
+// whole number of any length, for -tenths, without checking.
+// parseTempTenths validates it instead.
+func tenthsToFixedPointInt(bytes []byte) int32 {
+	negative := bytes[0] == '-'
+	idx := 0
+	if negative {
+		idx++
+	}
+
+	var val int32
+	for ; idx < len(bytes); idx++ {
+		val = val*10 + int32(bytes[idx]-'0')
+	}
+
+	if negative {
+		return -val
+	}
+	return val
+}
+
 func min(a, b int32) int32 {
 	if a < b {
 		return a
@@ -266,11 +1760,62 @@ const (
 	fnvPrime  = 1099511628211
 )
 
+// hashSeed is mixed into every hash's starting value, for -hash-seed. A
+// seed the input's author doesn't know keeps them from crafting names that
+// all land in one probe chain. Like hashPrefix it is global so that every
+// table in a run hashes keys the same way.
+var hashSeed uint64
+
 func newFnvHash() fnvHash {
-	return fnvOffset
+	return fnvOffset ^ hashSeed
+}
+
+// hashPrefix, if set, is how many leading bytes of a key hashBytes hashes,
+// for -hash-prefix. Keys are still compared in full, so this only trades
+// collisions between names sharing a prefix for cheaper hashing of long
+// names. It is global because every table must hash keys the same way.
+var hashPrefix int
+
+// doubleHash makes tables step along a probe chain by a stride from a
+// second hash of the key, for -probe=double, so names whose hashes land
+// together don't all share one cluster. Like hashPrefix it is global, so
+// the merged table probes the same way as the workers'.
+var doubleHash bool
+
+// probeStride returns the distance between the slots of key's probe chain
+// in a table of n slots: 1 for linear probing, or under doubleHash a
+// stride from a second hash of the whole key, coprime with n so the chain
+// still reaches every slot. get and add only ask once the first slot they
+// try is taken.
+func probeStride(key []byte, n uint64) uint64 {
+	if !doubleHash || n < 2 {
+		return 1
+	}
+	// FNV-1a, the other order of FNV-1's steps, from a different basis
+	h := uint64(fnvOffset^hashSeed) * fnvPrime
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= fnvPrime
+	}
+	// n-1 is coprime with n, so this stops by then
+	stride := 1 + h%(n-1)
+	for gcd(stride, n) != 1 {
+		stride++
+	}
+	return stride
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
 }
 
 func hashBytes(data []byte, start, end int) fnvHash {
+	if hashPrefix > 0 && end-start > hashPrefix {
+		end = start + hashPrefix
+	}
 	h := newFnvHash()
 	for i := start; i < end; i++ {
 		h *= fnvPrime
@@ -285,36 +1830,106 @@ type item struct {
 	value *stats
 }
 
+// matches reports whether the item holds key. The hash and length are
+// compared first so long keys only pay for bytes.Equal on a likely hit.
+func (it *item) matches(hash fnvHash, key []byte) bool {
+	return it.hash == hash && len(it.key) == len(key) && bytes.Equal(it.key, key)
+}
+
 type hashtable struct {
 	items []item
 	size  uint64
+
+	// histograms makes UpdateRow count every station's readings, for -iqr
+	histograms bool
+
+	// times makes UpdateRow note when each station's extremes were read,
+	// for -with-time
+	times bool
+
+	// hot, if set, is a direct-mapped cache of recently found items that
+	// get checks before probing, for -hot-cache. An entry copies the item,
+	// stats pointer included, rather than pointing at its slot, so grow,
+	// which moves items but not their stats, leaves it valid; only add
+	// replacing a key's stats has to clear it.
+	hot *[hotCacheSize]item
+
+	// limit, if set, is tripped once the table holds more than limitSize
+	// stations, for -max-stations
+	limit     *stationLimit
+	limitSize uint64
+
+	// maxProbes is how many occupied slots add steps over before growing
+	// the table early, for -max-probes
+	maxProbes int
 }
 
+// hotCacheSize is the number of -hot-cache entries, a power of two so an
+// entry is picked with a mask rather than the division probing needs
+const hotCacheSize = 512
+
 func NewHashTable(numBuckets uint64) *hashtable {
 	return &hashtable{
-		items: make([]item, numBuckets),
-		size:  0,
+		items:     make([]item, numBuckets),
+		size:      0,
+		maxProbes: defaultMaxProbes,
 	}
 }
 
+// maxLoadFactor is how full add lets a table get before doubling it, so
+// probing always finds an empty slot well before wrapping around.
+const maxLoadFactor = 0.75
+
+// defaultMaxProbes is how many occupied slots add steps over before
+// doubling the table even under maxLoadFactor, to break up a long cluster
+// rather than scan it on every miss, unless -max-probes says otherwise.
+// Stations whose hashes are equal, as -hash-prefix makes likely, collide
+// however big the table is, so this only applies once the table is at
+// least minProbeGrowLoad full.
+const (
+	defaultMaxProbes = 64
+	minProbeGrowLoad = 0.25
+)
+
 func (ht *hashtable) add(hash fnvHash, key []byte, v *stats) {
+	if float64(ht.size+1) > maxLoadFactor*float64(len(ht.items)) {
+		ht.grow()
+	}
+
 	index := hash % uint64(len(ht.items))
 	originalIndex := index
+	var stride uint64
 
 	// Keep probing until we find an empty slot
-	for {
+	for probes := 0; ; probes++ {
 		if ht.items[index].value == nil {
 			ht.items[index] = item{key: key, value: v, hash: hash}
 			ht.size++
+			if ht.limit != nil && ht.size > ht.limitSize {
+				ht.limit.exceeded.Store(true)
+			}
 			return
 		}
 
-		if bytes.Equal(ht.items[index].key, key) {
+		if ht.items[index].matches(hash, key) {
 			ht.items[index].value = v
+			if ht.hot != nil {
+				// The cached copy holds the old value
+				ht.hot[hash&(hotCacheSize-1)] = item{}
+			}
+			return
+		}
+
+		if probes == ht.maxProbes && float64(ht.size) >= minProbeGrowLoad*float64(len(ht.items)) {
+			ht.grow()
+			ht.add(hash, key, v)
 			return
 		}
 
-		index = (index + 1) % uint64(len(ht.items))
+		if stride == 0 {
+			stride = probeStride(key, uint64(len(ht.items)))
+		}
+		index = (index + stride) % uint64(len(ht.items))
 
 		if index == originalIndex {
 			panic("Hashtable is full")
@@ -322,9 +1937,31 @@ func (ht *hashtable) add(hash fnvHash, key []byte, v *stats) {
 	}
 }
 
+// grow doubles the table and reinserts its items. The stats stay where
+// they are, so pointers returned by get remain valid.
+func (ht *hashtable) grow() {
+	old := ht.items
+	ht.items = make([]item, 2*len(old))
+	ht.size = 0
+	for _, item := range old {
+		if item.value != nil {
+			ht.add(item.hash, item.key, item.value)
+		}
+	}
+}
+
 func (ht *hashtable) get(hash fnvHash, key []byte) *stats {
+	var hot *item
+	if ht.hot != nil {
+		hot = &ht.hot[hash&(hotCacheSize-1)]
+		if hot.matches(hash, key) {
+			return hot.value
+		}
+	}
+
 	index := hash % uint64(len(ht.items))
 	originalIndex := index
+	var stride uint64
 
 	// Keep probing until we find the key or an empty slot
 	for {
@@ -332,11 +1969,17 @@ func (ht *hashtable) get(hash fnvHash, key []byte) *stats {
 			return nil
 		}
 
-		if ht.items[index].hash == hash && bytes.Equal(ht.items[index].key, key) {
+		if ht.items[index].matches(hash, key) {
+			if hot != nil {
+				*hot = ht.items[index]
+			}
 			return ht.items[index].value
 		}
 
-		index = (index + 1) % uint64(len(ht.items))
+		if stride == 0 {
+			stride = probeStride(key, uint64(len(ht.items)))
+		}
+		index = (index + stride) % uint64(len(ht.items))
 
 		if index == originalIndex {
 			return nil
@@ -0,0 +1,1075 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runProcess(t *testing.T, contents string, opts options) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := process(&out, writeTempFile(t, contents), opts); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestProcessSingleLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"trailing newline", "Abha;12.3\n"},
+		{"no trailing newline", "Abha;12.3"},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 8} {
+			got := runProcess(t, tt.contents, options{workers: workers})
+			want := "{Abha=12.3/12.3/12.3}\n"
+			if got != want {
+				t.Errorf("%s, workers=%d: got %q, want %q", tt.name, workers, got, want)
+			}
+		}
+	}
+}
+
+func TestProcessLongFirstLine(t *testing.T) {
+	// The first block swallows almost the whole file, leaving later blocks
+	// starting at or beyond the end of the data
+	contents := "Aaaaaaaaaaaaaaaaaaaa;1.0\nB;2.0"
+	got := runProcess(t, contents, options{workers: 8})
+	want := "{Aaaaaaaaaaaaaaaaaaaa=1.0/1.0/1.0, B=2.0/2.0/2.0}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSelfTestStable(t *testing.T) {
+	path := writeTempFile(t, "Abha;12.3\nBeirut;-4.5\nAbha;-1.0\nCairo;30.1\nBeirut;9.9\n")
+	var report bytes.Buffer
+	if err := selfTest(&report, path, 10, options{workers: 4}); err != nil {
+		t.Fatalf("%v\n%s", err, report.String())
+	}
+}
+
+func TestProcessTrimKeys(t *testing.T) {
+	contents := "Las Vegas;1.0\nLas Vegas \t;3.0\nSan-Juan de la Cruz;2.0\nLas  Vegas;5.0\n"
+
+	got := runProcess(t, contents, options{workers: 2, trimKeys: true})
+	want := "{Las  Vegas=5.0/5.0/5.0, Las Vegas=1.0/2.0/3.0, San-Juan de la Cruz=2.0/2.0/2.0}\n"
+	if got != want {
+		t.Errorf("trimmed: got %q, want %q", got, want)
+	}
+
+	got = runProcess(t, contents, options{workers: 2})
+	want = "{Las  Vegas=5.0/5.0/5.0, Las Vegas=1.0/1.0/1.0, Las Vegas \t=3.0/3.0/3.0, San-Juan de la Cruz=2.0/2.0/2.0}\n"
+	if got != want {
+		t.Errorf("untrimmed: got %q, want %q", got, want)
+	}
+}
+
+func TestParseTemp(t *testing.T) {
+	valid := map[string]int32{"0.0": 0, "1.2": 12, "-1.2": -12, "12.3": 123, "-99.9": -999}
+	for in, want := range valid {
+		if got, ok := parseTemp([]byte(in)); !ok || got != want {
+			t.Errorf("parseTemp(%q) = %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "-", "1", "1.", ".1", "123.4", "1.23", "a.b", "--1.0", "1,2"} {
+		if _, ok := parseTemp([]byte(in)); ok {
+			t.Errorf("parseTemp(%q) accepted malformed input", in)
+		}
+	}
+}
+
+func TestParseTempGeneral(t *testing.T) {
+	valid := map[string]int32{
+		"1.2": 12, "-12.3": -123, "+4.5": 45, "123.4": 1234, "7": 70, "-7": -70,
+		"5.": 50, ".5": 5, "-.5": -5, "1.25": 13, "-1.25": -13, "1.249": 12, "0012.30": 123,
+	}
+	for in, want := range valid {
+		if got, ok := parseTempGeneral([]byte(in)); !ok || got != want {
+			t.Errorf("parseTempGeneral(%q) = %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "-", ".", "-.", "a.b", "--1.0", "1,2", "1.2.3", "1e3"} {
+		if _, ok := parseTempGeneral([]byte(in)); ok {
+			t.Errorf("parseTempGeneral(%q) accepted malformed input", in)
+		}
+	}
+	if got, _ := parseTempGeneral([]byte("99999999999")); got != math.MaxInt32 {
+		t.Errorf("parseTempGeneral did not saturate: got %d", got)
+	}
+}
+
+func TestParseTemperature(t *testing.T) {
+	valid := map[string]int32{
+		"0.0": 0, "-0.0": 0, "12.3": 123, "-99.9": -999, "123": 1230, "+1": 10,
+		"1.05": 11, "-1.05": -11, "2.": 20, ".7": 7,
+	}
+	for in, want := range valid {
+		if got, err := ParseTemperature([]byte(in)); err != nil || got != want {
+			t.Errorf("ParseTemperature(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "+", "-", ".", "1.2x", "x1.2", " 1.2", "1.2\r", "1..2"} {
+		if got, err := ParseTemperature([]byte(in)); err == nil || got != 0 {
+			t.Errorf("ParseTemperature(%q) = %d, %v; want an error", in, got, err)
+		}
+	}
+}
+
+func FuzzParseTemperature(f *testing.F) {
+	for _, seed := range []string{"12.3", "-9.9", "123.45", "+.5", "1e3", "", "-"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		got, err := ParseTemperature(b)
+		// Every canonical input must agree with the hot-path parser
+		if want, ok := parseTemp(b); ok && (err != nil || got != want) {
+			t.Errorf("ParseTemperature(%q) = %d, %v; parseTemp gives %d", b, got, err, want)
+		}
+	})
+}
+
+// A line truncated right after the sign of its temperature, as found
+// fuzzing ParseTemperature, is dropped as malformed rather than read past.
+func TestProcessLoneSign(t *testing.T) {
+	for _, contents := range []string{"a;1.0\nb;-\na;3.0\n", "a;1.0\na;3.0\nb;-"} {
+		for _, opts := range []options{
+			{workers: 1},
+			{workers: 2, generalParse: true},
+			{workers: 1, stats: true, diag: io.Discard},
+		} {
+			got := runProcess(t, contents, opts)
+			if want := "{a=1.0/2.0/3.0}\n"; got != want {
+				t.Errorf("%q %+v: got %q, want %q", contents, opts, got, want)
+			}
+		}
+	}
+
+	got := runProcess(t, "Abha   12.3\nBeirut    -\n", options{workers: 1, fixed: &fixedLayout{station: 7, temp: 5}})
+	if want := "{Abha=12.3/12.3/12.3}\n"; got != want {
+		t.Errorf("fixed: got %q, want %q", got, want)
+	}
+}
+
+func TestProcessParseModeStrict(t *testing.T) {
+	contents := "a;123.4\na;-7\nb;0.25\n"
+	for _, strict := range []bool{false, true} {
+		got := runProcess(t, contents, options{workers: 2, generalParse: true, strict: strict})
+		want := "{a=-7.0/58.2/123.4, b=0.3/0.3/0.3}\n"
+		if got != want {
+			t.Errorf("strict=%v: got %q, want %q", strict, got, want)
+		}
+	}
+}
+
+func TestProcessSkipsCommentLines(t *testing.T) {
+	contents := "# generated by createMeasurements\nAbha;12.3\n#Abha;99.9\nBeirut;-4.5\n# a longer comment that spans a block boundary\nAbha;-1.1\n#"
+	for workers := 1; workers <= 8; workers++ {
+		got := runProcess(t, contents, options{workers: workers, comment: '#', strict: true})
+		want := "{Abha=-1.1/5.6/12.3, Beirut=-4.5/-4.5/-4.5}\n"
+		if got != want {
+			t.Errorf("workers=%d: got %q, want %q", workers, got, want)
+		}
+	}
+}
+
+// writeBenchFile writes rows random measurements over a fixed set of
+// stations and returns the file's path.
+func writeBenchFile(b *testing.B, rows int) string {
+	b.Helper()
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&buf, "Station%03d;%.1f\n", rng.Intn(413), rng.Float64()*199.8-99.9)
+	}
+	path := filepath.Join(b.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// BenchmarkProcessPopulate compares pre-faulting the mapping with
+// MAP_POPULATE against lazy faulting, with the file in the page cache and,
+// where the cache can be dropped before each run, with it on disk. On a 1
+// CPU VM with 5GB of memory, over five runs of the 16MB file:
+//
+//	warm, lazy faulting   39.8-49.8ms
+//	warm, -populate       43.7-54.4ms
+//	cold, lazy faulting   62.3-70.8ms
+//	cold, -populate       59.3-72.0ms
+func BenchmarkProcessPopulate(b *testing.B) {
+	path := writeBenchFile(b, 1_000_000)
+	for _, cold := range []bool{false, true} {
+		for _, populate := range []bool{false, true} {
+			b.Run(fmt.Sprintf("cold=%v/populate=%v", cold, populate), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if cold {
+						b.StopTimer()
+						dropPageCache(b)
+						b.StartTimer()
+					}
+					if err := process(io.Discard, path, options{workers: runtime.NumCPU(), populate: populate}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// dropPageCache empties the page cache, so the next read of a file comes
+// from disk, or skips the benchmark where that takes privileges it hasn't
+// got.
+func dropPageCache(b *testing.B) {
+	syscall.Sync()
+	if err := os.WriteFile("/proc/sys/vm/drop_caches", []byte("3"), 0); err != nil {
+		b.Skipf("cannot drop the page cache: %v", err)
+	}
+}
+
+// writeLongNameFile writes rows readings over stations whose names share a
+// long common prefix, so hashing the whole name dominates the parse.
+func writeLongNameFile(b *testing.B, rows int) string {
+	b.Helper()
+	var buf bytes.Buffer
+	prefix := strings.Repeat("Very Long Station Name ", 8)
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&buf, "%03d %s;%d.%d\n", i%413, prefix, i%90, i%10)
+	}
+	path := filepath.Join(b.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// BenchmarkProcessHashPrefix compares hashing whole station names with
+// -hash-prefix on names of about 190 bytes that differ in their first few.
+func BenchmarkProcessHashPrefix(b *testing.B) {
+	path := writeLongNameFile(b, 1_000_000)
+	defer func(old int) { hashPrefix = old }(hashPrefix)
+	for _, n := range []int{0, 16} {
+		b.Run(fmt.Sprintf("hash-prefix=%d", n), func(b *testing.B) {
+			hashPrefix = n
+			for i := 0; i < b.N; i++ {
+				if err := process(io.Discard, path, options{workers: runtime.NumCPU()}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProcessPlain times one worker over input with the default
+// options, which take processPlain's loop, against the same input with
+// -trim-keys, which changes nothing on it but takes the general loop with
+// its fieldParser. A slower default path is a regression in the case the
+// challenge is timed on.
+func BenchmarkProcessPlain(b *testing.B) {
+	data, err := os.ReadFile(writeBenchFile(b, 1_000_000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts options
+	}{
+		{"default", options{}},
+		{"trim-keys", options{trimKeys: true}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				processData(data, 0, len(data), &tt.opts, tt.opts.newAccumulator()(1<<14))
+			}
+		})
+	}
+}
+
+// BenchmarkProcessHotCache compares probing the table for every row with
+// -hot-cache on the 413-station distribution, which the cache covers.
+func BenchmarkProcessHotCache(b *testing.B) {
+	path := writeBenchFile(b, 1_000_000)
+	for _, hot := range []bool{false, true} {
+		b.Run(fmt.Sprintf("hot-cache=%v", hot), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := process(io.Discard, path, options{workers: runtime.NumCPU(), hotCache: hot}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMeanTenthsRoundsHalfUp(t *testing.T) {
+	tests := []struct {
+		sum   int64
+		count uint64
+		want  string
+	}{
+		// Each of these is an exact .x5 that float64 arithmetic rounds down
+		{5, 2, "0.3"},
+		{25, 2, "1.3"},
+		{43, 2, "2.2"},
+		{91, 2, "4.6"},
+		{177, 2, "8.9"},
+		// Negative halves round toward positive infinity
+		{-5, 2, "-0.2"},
+		{-2999, 2, "-149.9"},
+		{-1, 3, "0.0"},
+		{-2, 3, "-0.1"},
+		{0, 1, "0.0"},
+		{999, 1, "99.9"},
+	}
+	for _, tt := range tests {
+		s := stats{sum: tt.sum, count: tt.count}
+		if got := string(appendTenths(nil, s.meanTenths())); got != tt.want {
+			t.Errorf("mean of sum=%d count=%d: got %s, want %s", tt.sum, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestProcessMeanIsExact(t *testing.T) {
+	got := runProcess(t, "A;0.5\nA;0.0\nB;2.0\nB;2.3\nC;-0.5\nC;0.0\n", options{workers: 2})
+	want := "{A=0.0/0.3/0.5, B=2.0/2.2/2.3, C=-0.5/-0.2/0.0}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestGoldenOutput checks the output against the reference 1BRC format byte
+// for byte: the braces, the ", " separator, "=" and "/" within each station,
+// one decimal everywhere and the trailing newline.
+func TestGoldenOutput(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "golden.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 2, 3, 8} {
+		var got bytes.Buffer
+		if err := process(&got, filepath.Join("testdata", "golden.txt"), options{workers: workers}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("workers=%d:\ngot  %q\nwant %q", workers, got.Bytes(), want)
+		}
+	}
+}
+
+func TestProcessReverseFields(t *testing.T) {
+	contents := "12.3;Abha\n-4.5;Las Vegas\n-10.1;Abha\n1.0;Zürich"
+	for workers := 1; workers <= 4; workers++ {
+		for _, strict := range []bool{false, true} {
+			got := runProcess(t, contents, options{workers: workers, reverseFields: true, strict: strict})
+			want := "{Abha=-10.1/1.1/12.3, Las Vegas=-4.5/-4.5/-4.5, Zürich=1.0/1.0/1.0}\n"
+			if got != want {
+				t.Errorf("workers=%d strict=%v: got %q, want %q", workers, strict, got, want)
+			}
+		}
+	}
+}
+
+func TestFahrenheitToCelsius(t *testing.T) {
+	tests := map[int32]int32{
+		320:  0,    // 32.0°F = 0.0°C
+		2120: 1000, // 212.0°F = 100.0°C
+		-400: -400, // -40.0°F = -40.0°C
+		0:    -178, // 0.0°F = -17.78°C
+		986:  370,  // 98.6°F = 37.0°C
+		-4:   -180, // -0.4°F = -17.999°C
+		500:  100,  // 50.0°F = 10.0°C
+		-999: -733, // -99.9°F = -73.28°C
+	}
+	for f, want := range tests {
+		if got := fahrenheitToCelsius(f); got != want {
+			t.Errorf("fahrenheitToCelsius(%d) = %d, want %d", f, got, want)
+		}
+	}
+}
+
+func TestProcessFahrenheit(t *testing.T) {
+	got := runProcess(t, "Abha;32.0\nAbha;50.0\nNuuk;-40.0\n", options{workers: 2, fahrenheit: true})
+	want := "{Abha=0.0/5.0/10.0, Nuuk=-40.0/-40.0/-40.0}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProcessParseUnit(t *testing.T) {
+	// 50.0F is 10.0C, 32.0F is 0.0C; the unsuffixed row takes -unit
+	contents := "Abha;10.0C\nAbha;50.0F\nNuuk;-40.0F\nNuuk;-40.0C\nAbha;32.0F\nLima;5.0\n"
+	for _, opts := range []options{
+		{workers: 2},
+		{workers: 2, generalParse: true},
+		{workers: 2, strict: true},
+		{workers: 2, windowSize: 4096},
+	} {
+		opts.parseUnit = true
+		if got, want := runProcess(t, contents, opts), "{Abha=0.0/6.7/10.0, Lima=5.0/5.0/5.0, Nuuk=-40.0/-40.0/-40.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+		opts.fahrenheit = true
+		if got, want := runProcess(t, contents, opts), "{Abha=0.0/6.7/10.0, Lima=-15.0/-15.0/-15.0, Nuuk=-40.0/-40.0/-40.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	got := runProcess(t, "Abha    50.0F \nNuuk    -4.0C \n", options{workers: 1, parseUnit: true, fixed: &fixedLayout{station: 8, temp: 6}})
+	if want := "{Abha=10.0/10.0/10.0, Nuuk=-4.0/-4.0/-4.0}\n"; got != want {
+		t.Errorf("fixed: got %q, want %q", got, want)
+	}
+
+	got = runProcess(t, contents, options{workers: 2, check: true, parseUnit: true})
+	if want := "6 valid lines, 0 malformed lines\n"; got != want {
+		t.Errorf("check: got %q, want %q", got, want)
+	}
+
+	// Any other suffix is still malformed
+	var out bytes.Buffer
+	if err := process(&out, writeTempFile(t, "a;12.3C\nb;55.1F\nc;1.0K\n"), options{workers: 1, check: true, parseUnit: true}); err == nil {
+		t.Error("check: got no error for a K suffix")
+	}
+	if want := "2 valid lines, 1 malformed lines\nfirst malformed line at byte 16\n"; out.String() != want {
+		t.Errorf("check: got %q, want %q", out.String(), want)
+	}
+	var diag bytes.Buffer
+	err := process(io.Discard, writeTempFile(t, "Abha;12.3K\n"), options{workers: 1, strict: true, parseUnit: true, diag: &diag})
+	if err == nil {
+		t.Error("got no error for a K suffix")
+	}
+}
+
+// TestProcessWorkerCountInvariance locks down the block boundary logic: for
+// every worker count from 1 to 16, and with and without a trailing newline,
+// the merged result must be the same.
+func TestProcessWorkerCountInvariance(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	for trial := 0; trial < 5; trial++ {
+		var buf bytes.Buffer
+		rows := 1 + rng.Intn(60)
+		for i := 0; i < rows; i++ {
+			// Wildly varying line lengths put block boundaries everywhere
+			name := strings.Repeat(string(rune('a'+rng.Intn(5))), 1+rng.Intn(40))
+			fmt.Fprintf(&buf, "%s;%.1f\n", name, rng.Float64()*199.8-99.9)
+		}
+		withNewline := buf.String()
+		withoutNewline := strings.TrimSuffix(withNewline, "\n")
+
+		want := runProcess(t, withNewline, options{workers: 1})
+		for workers := 1; workers <= 16; workers++ {
+			for _, contents := range []string{withNewline, withoutNewline} {
+				if got := runProcess(t, contents, options{workers: workers}); got != want {
+					t.Fatalf("trial %d, workers=%d, trailing newline=%v:\ngot  %q\nwant %q",
+						trial, workers, strings.HasSuffix(contents, "\n"), got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestProcessOpenErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	err := process(io.Discard, missing, options{workers: 1})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v, want fs.ErrNotExist", err)
+	}
+	if want := fmt.Sprintf("cannot open measurements file %q: no such file or directory", missing); err == nil || err.Error() != want {
+		t.Errorf("missing file: got %q, want %q", err, want)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can read unreadable files")
+	}
+	unreadable := writeTempFile(t, "a;1.0\n")
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	err = process(io.Discard, unreadable, options{workers: 1})
+	if !errors.Is(err, fs.ErrPermission) || !strings.Contains(err.Error(), "must be readable") {
+		t.Errorf("unreadable file: got %v", err)
+	}
+}
+
+func TestMapFileSharedFallback(t *testing.T) {
+	defer func(old func(int, int64, int, int, int) ([]byte, error)) { mmap = old }(mmap)
+	var sharedFlags []int
+	mmap = func(fd int, offset int64, length, prot, flags int) ([]byte, error) {
+		if flags&syscall.MAP_PRIVATE != 0 {
+			return nil, syscall.EINVAL
+		}
+		sharedFlags = append(sharedFlags, flags)
+		return syscall.Mmap(fd, offset, length, prot, flags)
+	}
+
+	var diag bytes.Buffer
+	path := writeTempFile(t, "a;1.0\nb;2.0\n")
+	var out bytes.Buffer
+	if err := process(&out, path, options{workers: 2, verbose: true, diag: &diag}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{a=1.0/1.0/1.0, b=2.0/2.0/2.0}\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if len(sharedFlags) != 1 || sharedFlags[0]&syscall.MAP_SHARED == 0 {
+		t.Errorf("expected one shared mapping, got flags %v", sharedFlags)
+	}
+	if !strings.Contains(diag.String(), "retrying shared") {
+		t.Errorf("fallback not logged: %q", diag.String())
+	}
+}
+
+func TestProcessHashPrefix(t *testing.T) {
+	defer func(old int) { hashPrefix = old }(hashPrefix)
+
+	// Every name collides on a 4-byte prefix, and two differ only in length
+	seeds := filepath.Join(t.TempDir(), "seeds.txt")
+	if err := os.WriteFile(seeds, []byte("Station\nStat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seedList, err := loadSeedStations(seeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := "StationA;1.0\nStationB;2.0\nStation;3.0\nStat;4.0\nStationA;5.0\n"
+
+	hashPrefix = 0
+	want := runProcess(t, contents, options{workers: 2, seeds: seedList, renames: map[string]string{"StationB": "Stat"}})
+	hashPrefix = 4
+	if got := runProcess(t, contents, options{workers: 2, seeds: seedList, renames: map[string]string{"StationB": "Stat"}}); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProcessHashSeed(t *testing.T) {
+	defer func(old uint64) { hashSeed = old }(hashSeed)
+
+	var buf strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%37, i%90, i%10)
+	}
+	contents := buf.String()
+	renames := map[string]string{"Station01": "Station02"}
+
+	hashSeed = 0
+	unseeded := hashBytes([]byte("Station01"), 0, len("Station01"))
+	want := runProcess(t, contents, options{workers: 1, renames: renames})
+
+	hashSeed = 0x9e3779b97f4a7c15
+	if hashBytes([]byte("Station01"), 0, len("Station01")) == unseeded {
+		t.Error("hash-seed did not change the hash")
+	}
+	for _, opts := range []options{
+		{workers: 4, renames: renames},
+		{workers: 2, renames: renames, windowSize: 4096},
+		{workers: 2, renames: renames, lowMem: true},
+		{workers: 2, renames: renames, seeds: [][]byte{[]byte("Station05")}},
+	} {
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %.200q, want %.200q", opts, got, want)
+		}
+	}
+}
+
+func TestProcessRecordSep(t *testing.T) {
+	var lines []string
+	for i := 0; i < 3000; i++ {
+		lines = append(lines, fmt.Sprintf("Station%02d;%d.%d", i%37, i%90, i%10))
+	}
+	want := runProcess(t, strings.Join(lines, "\n")+"\n", options{workers: 1})
+	wantRows := fmt.Sprintln(len(lines) + 1)
+
+	// A newline inside a NUL-separated record is just part of the name
+	nul := strings.Join(lines, "\x00") + "\x00Multi\nLine;1.0"
+	wantMulti := strings.Replace(want, "{", "{Multi\nLine=1.0/1.0/1.0, ", 1)
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 5},
+		{workers: 3, windowSize: 4096},
+		{workers: 3, dispatchBatch: 700},
+		{workers: 2, strict: true},
+	} {
+		opts.recordSep, opts.hasRecordSep = 0, true
+		opts.diag = io.Discard
+		if got := runProcess(t, nul, opts); got != wantMulti {
+			t.Errorf("%+v: got %.200q, want %.200q", opts, got, wantMulti)
+		}
+		opts.countOnly = true
+		if got := runProcess(t, nul, opts); got != wantRows {
+			t.Errorf("%+v: got %q rows, want %q", opts, got, wantRows)
+		}
+	}
+
+	for _, tt := range []struct {
+		in   string
+		want byte
+	}{{"|", '|'}, {`\x00`, 0}, {`\t`, '\t'}, {`\000`, 0}} {
+		if got, err := parseByteFlag(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseByteFlag(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"ab", `\u1234`, "é", `\x0`} {
+		if _, err := parseByteFlag(in); err == nil {
+			t.Errorf("parseByteFlag(%q) accepted", in)
+		}
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	ht := NewHashTable(16)
+	ht.hot = new([hotCacheSize]item)
+	key := []byte("a")
+	hash := hashBytes(key, 0, len(key))
+	first, second := &stats{count: 1}, &stats{count: 2}
+	ht.add(hash, key, first)
+	if ht.get(hash, key) != first || ht.get(hash, key) != first {
+		t.Fatal("lookup through the cache failed")
+	}
+	ht.add(hash, key, second)
+	if got := ht.get(hash, key); got != second {
+		t.Errorf("cache kept the overwritten value: got %+v", got)
+	}
+	// Same slot, different key: a miss, not a false hit
+	other := []byte("b")
+	if got := ht.get(hash, other); got != nil {
+		t.Errorf("get of a missing key sharing the hash: got %+v", got)
+	}
+
+	var buf strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&buf, "Station%03d;%d.%d\n", i%413, i%90, i%10)
+	}
+	contents := buf.String()
+	want := runProcess(t, contents, options{workers: 3})
+	if got := runProcess(t, contents, options{workers: 3, hotCache: true}); got != want {
+		t.Errorf("got %.200q, want %.200q", got, want)
+	}
+}
+
+func TestProcessBOM(t *testing.T) {
+	contents := "\xef\xbb\xbfAbha;1.0\nBonn;2.0\nAbha;3.0\n"
+	want := "{Abha=1.0/2.0/3.0, Bonn=2.0/2.0/2.0}\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 1, windowSize: 4096},
+		{workers: 2, strict: true},
+		{workers: 1, autoWorkers: true},
+	} {
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+	if got := runProcess(t, contents, options{workers: 2, countOnly: true}); got != "3\n" {
+		t.Errorf("count-only: got %q", got)
+	}
+	if got := runProcess(t, contents, options{workers: 2, check: true}); !strings.HasPrefix(got, "3 valid lines, 0 malformed") {
+		t.Errorf("check: got %q", got)
+	}
+	// A BOM alone is an empty file
+	if got := runProcess(t, "\xef\xbb\xbf", options{workers: 1, countOnly: true}); got != "0\n" {
+		t.Errorf("BOM only: got %q", got)
+	}
+}
+
+func TestProcessSqueezeDelim(t *testing.T) {
+	contents := "a;1.0\nb;;2.0\na;;;3.0\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{workers: 1, squeezeDelim: true}, "{a=1.0/2.0/3.0, b=2.0/2.0/2.0}\n"},
+		{options{workers: 2, squeezeDelim: true, stats: true}, "{a=1.0/2.0/3.0, b=2.0/2.0/2.0}\n"},
+		{options{workers: 2, squeezeDelim: true, check: true}, "3 valid lines, 0 malformed lines\n"},
+		// Without it the temperature starts with a delimiter
+		{options{workers: 1, stats: true}, "{a=1.0/1.0/1.0}\n"},
+	}
+	for _, tt := range tests {
+		if got := runProcess(t, contents, tt.opts); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+
+	got := runProcess(t, "1.0::::a\n3.0::a\n", options{workers: 1, squeezeDelim: true, delimiter: []byte("::"), reverseFields: true})
+	if want := "{a=1.0/2.0/3.0}\n"; got != want {
+		t.Errorf("reversed: got %q, want %q", got, want)
+	}
+}
+
+func TestProcessDelimiterStr(t *testing.T) {
+	// Station names may contain the parts of the separator on their own;
+	// like ; the first separator on the line ends the station
+	contents := "Paris,Texas, 12.3\nLondon, -1.0\nParis,Texas, 2.3\nBad;1.0\n"
+	want := "{London=-1.0/-1.0/-1.0, Paris,Texas=2.3/7.3/12.3}\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 2, stats: true},
+	} {
+		opts.delimiter = []byte(", ")
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	got := runProcess(t, "12.3 | Abha\n-1.0 | Abha\n", options{workers: 1, delimiter: []byte(" | "), reverseFields: true})
+	if want := "{Abha=-1.0/5.7/12.3}\n"; got != want {
+		t.Errorf("reversed: got %q, want %q", got, want)
+	}
+
+	var out bytes.Buffer
+	err := process(&out, writeTempFile(t, contents), options{workers: 2, delimiter: []byte(", "), check: true})
+	if err == nil || !strings.HasPrefix(out.String(), "3 valid lines, 1 malformed lines\n") {
+		t.Errorf("check: got %v, %q", err, out.String())
+	}
+}
+
+func TestSplitBlocksFileEnd(t *testing.T) {
+	// Ending on a newline, one byte before it, and with a last line of a
+	// single byte, so some worker count puts a natural boundary on each of
+	// the last bytes
+	for _, contents := range []string{"ab;1.0\ncd;2.0\n", "ab;1.0\ncd;2.0", "ab;1.0\ncd;2.0\nx", "a;1.0\n\n"} {
+		data := []byte(contents)
+		for start := 0; start < len(data); start = nextLine(data, start, len(data), '\n') {
+			for workers := 1; workers <= len(data)+2; workers++ {
+				blocks := splitBlocks(data, start, len(data), workers, '\n')
+				if len(blocks) != workers {
+					t.Fatalf("%q from %d, %d workers: got %d blocks", contents, start, workers, len(blocks))
+				}
+				at := start
+				for i, blk := range blocks {
+					if blk.start != at || blk.end < blk.start {
+						t.Fatalf("%q from %d, %d workers: block %d is %+v after byte %d", contents, start, workers, i, blk, at)
+					}
+					if blk.end < len(data) && blk.end > blk.start && data[blk.end-1] != '\n' {
+						t.Errorf("%q from %d, %d workers: block %d %+v ends mid-line", contents, start, workers, i, blk)
+					}
+					at = blk.end
+				}
+				if at != len(data) {
+					t.Errorf("%q from %d, %d workers: blocks end at %d of %d", contents, start, workers, at, len(data))
+				}
+			}
+		}
+
+		// A single worker is the reference for whatever the input holds
+		want := runProcess(t, contents, options{workers: 1, stats: true})
+		for workers := 2; workers <= len(data)+2; workers++ {
+			if got := runProcess(t, contents, options{workers: workers, stats: true}); got != want {
+				t.Errorf("%q, %d workers: got %q, want %q", contents, workers, got, want)
+			}
+		}
+	}
+}
+
+func TestHashTableGrows(t *testing.T) {
+	ht := NewHashTable(8)
+	values := make(map[string]*stats)
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("station-%d", i))
+		v := &stats{count: uint64(i)}
+		values[string(key)] = v
+		ht.add(hashBytes(key, 0, len(key)), key, v)
+	}
+	if ht.size != 1000 || float64(ht.size) > maxLoadFactor*float64(len(ht.items)) {
+		t.Fatalf("got %d stations in %d buckets", ht.size, len(ht.items))
+	}
+	for key, v := range values {
+		if got := ht.get(hashBytes([]byte(key), 0, len(key)), []byte(key)); got != v {
+			t.Errorf("%s: got %p, want %p", key, got, v)
+		}
+	}
+
+	// Equal hashes collide at any size, so they mustn't make it grow
+	same := NewHashTable(1024)
+	for i := 0; i < 200; i++ {
+		same.add(42, []byte(fmt.Sprintf("station-%d", i)), &stats{})
+	}
+	if len(same.items) != 1024 {
+		t.Errorf("colliding hashes grew the table to %d buckets", len(same.items))
+	}
+
+	// A cluster longer than -max-probes grows a table a quarter full, well
+	// under maxLoadFactor
+	for _, tt := range []struct {
+		maxProbes int
+		want      int
+	}{{0, 64}, {4, 128}} {
+		ht := options{maxProbes: tt.maxProbes}.newAccumulator()(64).(*hashtable)
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("station-%d", i))
+			ht.add(fnvHash(i/10), key, &stats{})
+		}
+		if len(ht.items) != tt.want {
+			t.Errorf("max-probes %d: got %d buckets, want %d", tt.maxProbes, len(ht.items), tt.want)
+		}
+	}
+}
+
+func TestHashTableDoubleHashing(t *testing.T) {
+	defer func(old bool) { doubleHash = old }(doubleHash)
+	doubleHash = true
+
+	for _, n := range []uint64{2, 7, 12, 1024, 1 << 14} {
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("station-%d", i))
+			if s := probeStride(key, n); s == 0 || s >= n || gcd(s, n) != 1 {
+				t.Fatalf("probeStride(%s, %d) = %d", key, n, s)
+			}
+		}
+	}
+
+	// Equal hashes all start at one slot and, with the table not a power of
+	// two, only reach the rest through coprime strides
+	for _, count := range []int{9, 300} {
+		ht := NewHashTable(12)
+		values := make(map[string]*stats)
+		for i := 0; i < count; i++ {
+			key := []byte(fmt.Sprintf("station-%d", i))
+			v := &stats{count: uint64(i)}
+			values[string(key)] = v
+			ht.add(42, key, v)
+		}
+		if ht.size != uint64(count) {
+			t.Fatalf("%d keys: got size %d", count, ht.size)
+		}
+		for key, v := range values {
+			if got := ht.get(42, []byte(key)); got != v {
+				t.Errorf("%d keys, %s: got %p, want %p", count, key, got, v)
+			}
+		}
+		if ht.get(42, []byte("missing")) != nil {
+			t.Errorf("%d keys: found a missing key", count)
+		}
+	}
+
+	defer func(old int) { hashPrefix = old }(hashPrefix)
+	hashPrefix = 3
+	contents := "aaa1;1.0\naaa2;2.0\naaa1;3.0\naab;4.0\naaa3;5.0\n"
+	if got, want := runProcess(t, contents, options{workers: 2}), "{aaa1=1.0/2.0/3.0, aaa2=2.0/2.0/2.0, aaa3=5.0/5.0/5.0, aab=4.0/4.0/4.0}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// probeChain returns how many slots get looks at to find key in ht.
+func probeChain(ht *hashtable, hash fnvHash, key []byte) int {
+	n := uint64(len(ht.items))
+	index, stride := hash%n, probeStride(key, n)
+	for probes := 1; ; probes++ {
+		if ht.items[index].value == nil || ht.items[index].matches(hash, key) {
+			return probes
+		}
+		index = (index + stride) % n
+	}
+}
+
+// BenchmarkHashTableProbe compares looking up stations with linear probing
+// and -probe=double in a half full table of clustered hashes: runs of
+// eight names sharing a hash, on consecutive home slots, as -hash-prefix
+// gives names that differ only at the end. It reports the mean probe chain.
+func BenchmarkHashTableProbe(b *testing.B) {
+	defer func(old bool) { doubleHash = old }(doubleHash)
+	const stations = 4096
+	keys := make([][]byte, stations)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("station-%d", i))
+	}
+	hashOf := func(i int) fnvHash { return fnvHash(1<<20 + i/8) }
+
+	for _, double := range []bool{false, true} {
+		b.Run(fmt.Sprintf("double=%v", double), func(b *testing.B) {
+			doubleHash = double
+			ht := NewHashTable(2 * stations)
+			for i, key := range keys {
+				ht.add(hashOf(i), key, &stats{})
+			}
+			probes := 0
+			for i, key := range keys {
+				probes += probeChain(ht, hashOf(i), key)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				j := i % stations
+				if ht.get(hashOf(j), keys[j]) == nil {
+					b.Fatal("missing station")
+				}
+			}
+			b.ReportMetric(float64(probes)/stations, "probes/lookup")
+		})
+	}
+}
+
+func TestProcessHeapReadOwnsKeys(t *testing.T) {
+	path := writeTempFile(t, "b;1.0\na;2.0\nb;3.0\n")
+	for _, tt := range []struct {
+		opts  options
+		owned bool
+	}{
+		{options{workers: 2}, false},
+		{options{workers: 2, heapReadBelow: 1 << 20}, true},
+		{options{workers: 2, heapReadBelow: 4}, false},
+		{options{workers: 2, windowSize: 4096}, true},
+	} {
+		var keys []string
+		var kept [][]byte
+		gotOwned := false
+		tt.opts.collect = func(res *hashtable, owned bool) {
+			gotOwned = owned
+			for _, item := range populatedItems(res) {
+				if owned {
+					kept = append(kept, item.key)
+				}
+				keys = append(keys, string(item.key))
+			}
+		}
+		if err := process(io.Discard, path, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if gotOwned != tt.owned {
+			t.Errorf("%+v: got owned %v, want %v", tt.opts, gotOwned, tt.owned)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != "a,b" {
+			t.Errorf("%+v: got keys %q", tt.opts, keys)
+		}
+		// Owned keys stay readable once process has returned
+		for _, key := range kept {
+			if string(key) != "a" && string(key) != "b" {
+				t.Errorf("%+v: kept key %q changed", tt.opts, key)
+			}
+		}
+	}
+}
+
+func TestParseTempSci(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int32
+		ok   bool
+	}{
+		{"12.3", 123, true},
+		{"1.2e1", 120, true},
+		{"-4.56E-1", -5, true},
+		{"2.5e-2", 0, true},
+		{"1e999", math.MaxInt32, true},
+		{"-1e999", -math.MaxInt32, true},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1.2e", 12, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTempSci([]byte(tt.in))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTempSci(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProcessSciNotation(t *testing.T) {
+	contents := "a;1.2e1\nb;-3.5\na;-1.5E0\n"
+	for _, strict := range []bool{false, true} {
+		got := runProcess(t, contents, options{workers: 2, generalParse: true, sciNotation: true, strict: strict})
+		if want := "{a=-1.5/5.3/12.0, b=-3.5/-3.5/-3.5}\n"; got != want {
+			t.Errorf("strict=%v: got %q, want %q", strict, got, want)
+		}
+	}
+}
+
+func TestParseTempTenths(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int32
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"-5", -5, true},
+		{"123", 123, true},
+		{"-999", -999, true},
+		{"+42", 42, true},
+		{"99999999999", math.MaxInt32, true},
+		{"12.3", 12, false},
+		{"-", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTempTenths([]byte(tt.in))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTempTenths(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+		if tt.ok && tt.in[0] != '+' && len(tt.in) < 10 {
+			if got := tenthsToFixedPointInt([]byte(tt.in)); got != tt.want {
+				t.Errorf("tenthsToFixedPointInt(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestProcessTenths(t *testing.T) {
+	contents := "a;123\nb;-5\na;0\nb;-999\n"
+	for _, strict := range []bool{false, true} {
+		got := runProcess(t, contents, options{workers: 2, tenths: true, strict: strict})
+		if want := "{a=0.0/6.2/12.3, b=-99.9/-50.2/-0.5}\n"; got != want {
+			t.Errorf("strict=%v: got %q, want %q", strict, got, want)
+		}
+	}
+	if got := runProcess(t, "a;12.3\n", options{workers: 1, tenths: true, stats: true}); got != "{}\n" {
+		t.Errorf("decimal rejected: got %q, want %q", got, "{}\n")
+	}
+}
+
+// TestProcessOnlyNewlines checks a file of blank lines yields no stations,
+// wherever the block boundaries fall among them.
+func TestProcessOnlyNewlines(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		path := writeTempFile(t, strings.Repeat("\n", n))
+		for _, workers := range []int{1, 2, 3, 4, 5, 8} {
+			for name, opts := range map[string]options{
+				"blocks":   {workers: workers},
+				"dispatch": {workers: workers, dispatchBatch: 1},
+				"windowed": {workers: workers, windowSize: 4096},
+			} {
+				var out bytes.Buffer
+				if err := process(&out, path, opts); err != nil {
+					t.Fatalf("%d newlines, %s, workers=%d: %v", n, name, workers, err)
+				}
+				if out.String() != "{}\n" {
+					t.Errorf("%d newlines, %s, workers=%d: got %q, want {}", n, name, workers, out.String())
+				}
+			}
+
+			var diag bytes.Buffer
+			if err := process(io.Discard, path, options{workers: workers, stats: true, diag: &diag}); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("skipped %d malformed lines\n  empty line: %d\n", n, n); diag.String() != want {
+				t.Errorf("%d newlines, workers=%d: diag got %q, want %q", n, workers, diag.String(), want)
+			}
+		}
+	}
+}
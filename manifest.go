@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// runManifest describes a run for -manifest-out: what was read, how, and
+// how long it took, as a JSON sidecar to the result. process fills it in
+// as it goes; its methods do nothing on a nil manifest, so the paths that
+// record into it needn't check for -manifest-out.
+type runManifest struct {
+	input    string
+	size     int64 // -1 for a stream, whose size isn't known up front
+	rows     uint64
+	stations int
+	workers  int
+	scanPath string
+	elapsed  time.Duration
+}
+
+// scanned records the input's size and the path process took through it:
+// mmap, heap, windowed, stream, utf16-stream or tar.
+func (m *runManifest) scanned(size int64, path string) {
+	if m == nil {
+		return
+	}
+	m.size, m.scanPath = size, path
+}
+
+// counted records the rows and distinct stations of res, the merged table
+// before any output filter, and the workers that built it.
+func (m *runManifest) counted(res *hashtable, workers int) {
+	if m == nil {
+		return
+	}
+	m.rows, m.stations, m.workers = 0, 0, workers
+	for _, item := range res.items {
+		if item.value != nil && item.value.count > 0 {
+			m.rows += item.value.count
+			m.stations++
+		}
+	}
+}
+
+// countedRows records the row count of a -count-only run.
+func (m *runManifest) countedRows(rows, workers int) {
+	if m == nil {
+		return
+	}
+	m.rows, m.workers = uint64(rows), workers
+}
+
+// writeManifest writes m to fileName as a single JSON object.
+func writeManifest(fileName string, m *runManifest) error {
+	f, err := createOutputFile(fileName)
+	if err != nil {
+		return err
+	}
+	b := bufio.NewWriter(f)
+	b.WriteString(`{"input":`)
+	writeJSONString(b, []byte(m.input))
+	b.WriteString(`,"input_bytes":`)
+	if m.size < 0 {
+		b.WriteString("null")
+	} else {
+		b.WriteString(strconv.FormatInt(m.size, 10))
+	}
+	fmt.Fprintf(b, `,"rows":%d,"stations":%d,"workers":%d,"scan_path":`, m.rows, m.stations, m.workers)
+	writeJSONString(b, []byte(m.scanPath))
+	fmt.Fprintf(b, `,"elapsed_seconds":%.6f}`+"\n", m.elapsed.Seconds())
+	if err := b.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
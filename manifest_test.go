@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessManifest(t *testing.T) {
+	contents := "a;1.0\nb;2.0\na;3.0\n"
+	path := writeTempFile(t, contents)
+	tests := []struct {
+		opts     options
+		scanPath string
+		stations int
+	}{
+		{options{workers: 2}, "mmap", 2},
+		{options{workers: 2, heapReadBelow: 1 << 20}, "heap", 2},
+		{options{workers: 2, windowSize: 8}, "windowed", 2},
+		{options{workers: 2, countOnly: true}, "mmap", 0},
+		{options{workers: 2, minCount: 2}, "mmap", 2},
+	}
+	for _, tt := range tests {
+		m := &runManifest{input: path}
+		tt.opts.manifest = m
+		if err := process(io.Discard, path, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		m.elapsed = 1500 * time.Millisecond
+
+		out := filepath.Join(t.TempDir(), "manifest.json")
+		if err := writeManifest(out, m); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Input      string  `json:"input"`
+			InputBytes *int64  `json:"input_bytes"`
+			Rows       uint64  `json:"rows"`
+			Stations   int     `json:"stations"`
+			Workers    int     `json:"workers"`
+			ScanPath   string  `json:"scan_path"`
+			Elapsed    float64 `json:"elapsed_seconds"`
+		}
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("invalid JSON %q: %v", raw, err)
+		}
+		if got.Input != path || got.InputBytes == nil || *got.InputBytes != int64(len(contents)) || got.Rows != 3 ||
+			got.Stations != tt.stations || got.Workers != 2 || got.ScanPath != tt.scanPath || got.Elapsed != 1.5 {
+			t.Errorf("%s: got %s", tt.scanPath, raw)
+		}
+	}
+
+	// A stream's size isn't known
+	m := &runManifest{input: "fifo", size: -1, scanPath: "stream"}
+	out := filepath.Join(t.TempDir(), "manifest.json")
+	if err := writeManifest(out, m); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(out); !strings.Contains(string(raw), `"input_bytes":null`) {
+		t.Errorf("stream: got %s", raw)
+	}
+}
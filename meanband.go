@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// meanBand keeps the stations whose mean is below or above a bound, for
+// -mean-below and -mean-above, both when both are set. The bounds are in
+// tenths of a degree and are compared with the mean as printed, rounded by
+// meanTenths, so a station printed as 40.0 isn't above 40.
+type meanBand struct {
+	below, above       int64
+	hasBelow, hasAbove bool
+}
+
+// parseMeanBound parses a -mean-below or -mean-above bound in degrees,
+// rounding it to the nearest tenth.
+func parseMeanBound(s string) (int64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > 1e15 {
+		return 0, fmt.Errorf("%q is not a temperature", s)
+	}
+	return int64(math.Round(v * 10)), nil
+}
+
+func (b meanBand) active() bool {
+	return b.hasBelow || b.hasAbove
+}
+
+func (b meanBand) keeps(s *stats) bool {
+	mean := s.meanTenths()
+	return (!b.hasBelow || mean < b.below) && (!b.hasAbove || mean > b.above)
+}
+
+// filterMean removes the stations of ht whose mean b doesn't keep, and
+// returns the table left and how many it removed.
+func filterMean(ht *hashtable, b meanBand) (*hashtable, int) {
+	res := NewHashTable(uint64(len(ht.items)))
+	dropped := 0
+	for _, item := range ht.items {
+		if item.value == nil {
+			continue
+		}
+		if !b.keeps(item.value) {
+			dropped++
+			continue
+		}
+		res.add(item.hash, item.key, item.value)
+	}
+	return res, dropped
+}
+
+// reportMeanDropped writes how many stations -mean-below and -mean-above
+// left out to w.
+func reportMeanDropped(w io.Writer, dropped int) {
+	if w == nil {
+		return
+	}
+	fmt.Fprintf(w, "dropped %d stations with a mean outside -mean-below/-mean-above\n", dropped)
+}
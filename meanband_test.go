@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessMeanBand(t *testing.T) {
+	// c's mean of 40.05 rounds to 40.1, and d's is exactly 40.0
+	const contents = "a;1.0\nb;-12.0\na;3.0\nc;40.0\nc;40.1\nd;40.0\ne;45.0\ne;45.0\ne;48.0\n"
+	band := func(below, above string) meanBand {
+		var b meanBand
+		var err error
+		if below != "" {
+			b.hasBelow = true
+			if b.below, err = parseMeanBound(below); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if above != "" {
+			b.hasAbove = true
+			if b.above, err = parseMeanBound(above); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b
+	}
+	tests := []struct {
+		below, above string
+		leaderboard  bool
+		want         string
+	}{
+		{"-10", "", false, "{b=-12.0/-12.0/-12.0}\n"},
+		{"", "40", false, "{c=40.0/40.1/40.1, e=45.0/46.0/48.0}\n"},
+		{"", "40", true, "{e=45.0/46.0/48.0, c=40.0/40.1/40.1}\n"},
+		{"41", "2.0", false, "{c=40.0/40.1/40.1, d=40.0/40.0/40.0}\n"},
+		{"2", "", false, "{b=-12.0/-12.0/-12.0}\n"},
+		{"-20", "", false, "{}\n"},
+	}
+	for _, tt := range tests {
+		opts := options{workers: 2, meanBand: band(tt.below, tt.above), leaderboard: tt.leaderboard}
+		if got := runProcess(t, contents, opts); got != tt.want {
+			t.Errorf("below %q, above %q: got %q, want %q", tt.below, tt.above, got, tt.want)
+		}
+	}
+
+	if _, err := parseMeanBound("warm"); err == nil {
+		t.Error("parseMeanBound accepted \"warm\"")
+	}
+}
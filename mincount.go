@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// dropRare removes the stations of ht with fewer than minCount rows, for
+// -min-count, and returns the table left and how many it removed.
+func dropRare(ht *hashtable, minCount uint64) (*hashtable, int) {
+	res := NewHashTable(uint64(len(ht.items)))
+	dropped := 0
+	for _, item := range ht.items {
+		if item.value == nil {
+			continue
+		}
+		if item.value.count < minCount {
+			dropped++
+			continue
+		}
+		res.add(item.hash, item.key, item.value)
+	}
+	return res, dropped
+}
+
+// reportDropped writes how many stations -min-count left out to w.
+func reportDropped(w io.Writer, dropped int, minCount uint64) {
+	if w == nil {
+		return
+	}
+	fmt.Fprintf(w, "dropped %d stations with fewer than %d rows\n", dropped, minCount)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestProcessMinCount(t *testing.T) {
+	path := writeTempFile(t, "a;1.0\nb;2.0\na;3.0\nc;4.0\nc;5.0\nc;6.0\n")
+	tests := []struct {
+		opts    options
+		want    string
+		dropped int
+	}{
+		{options{workers: 2, minCount: 2}, "{a=1.0/2.0/3.0, c=4.0/5.0/6.0}\n", 1},
+		{options{workers: 2, minCount: 2, leaderboard: true}, "{c=4.0/5.0/6.0, a=1.0/2.0/3.0}\n", 1},
+		{options{workers: 2, minCount: 2, lowMem: true}, "{a=1.0/2.0/3.0, c=4.0/5.0/6.0}\n", 1},
+		{options{workers: 2, minCount: 3}, "{c=4.0/5.0/6.0}\n", 2},
+		{options{workers: 2, minCount: 4}, "{}\n", 3},
+	}
+	for _, tt := range tests {
+		var out, diag bytes.Buffer
+		tt.opts.stats = true
+		tt.opts.diag = &diag
+		if err := process(&out, path, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, out.String(), tt.want)
+		}
+		wantDiag := fmt.Sprintf("dropped %d stations with fewer than %d rows\n", tt.dropped, tt.opts.minCount)
+		if !strings.HasSuffix(diag.String(), wantDiag) {
+			t.Errorf("%+v: diag got %q, want it to end %q", tt.opts, diag.String(), wantDiag)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "syscall"
+
+// mapPopulate pre-faults the whole mapping at mmap time.
+const mapPopulate = syscall.MAP_POPULATE
+
+func adviseSequential(data []byte) {
+	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+}
+
+func adviseWillNeed(data []byte) {
+	syscall.Madvise(data, syscall.MADV_WILLNEED)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+// mapPopulate is a no-op where MAP_POPULATE isn't available.
+const mapPopulate = 0
+
+func adviseSequential(data []byte) {}
+
+func adviseWillNeed(data []byte) {}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// crlfReader strips the \r of each \r\n read through it, for
+// -normalize-newlines, so a Windows export streams as if its lines ended
+// in \n alone. A \r that isn't followed by a \n is kept. When a read ends
+// on a \r the next byte is peeked at to decide, which on a pipe waits for
+// it as the rest of the line would anyway.
+//
+// It only feeds the streamed paths: a mapped file is parsed in place, so
+// it can't be made shorter as it's read.
+type crlfReader struct {
+	r *bufio.Reader
+}
+
+func newCRLFReader(r io.Reader) *crlfReader {
+	return &crlfReader{r: bufio.NewReader(r)}
+}
+
+func (c *crlfReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	w := 0
+	for i := 0; i < n; i++ {
+		if p[i] == '\r' {
+			if i+1 < n {
+				if p[i+1] == '\n' {
+					continue
+				}
+			} else if next, _ := c.r.Peek(1); len(next) == 1 && next[0] == '\n' {
+				continue
+			}
+		}
+		p[w] = p[i]
+		w++
+	}
+	return w, err
+}
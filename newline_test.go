@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCRLFReader(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"a;1.0\r\nb;2.0\r\n", "a;1.0\nb;2.0\n"},
+		{"a;1.0\r\nb;2.0\n", "a;1.0\nb;2.0\n"},
+		{"a\rb;1.0\r\r\n", "a\rb;1.0\r\n"}, // only the \r right before a \n
+		{"a;1.0\r", "a;1.0\r"},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := io.ReadAll(newCRLFReader(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%q, one byte at a time %v: got %q, want %q", tt.in, oneByte, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestProcessNormalizeNewlines(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\r\n", i%37, i%90-45, i%10)
+	}
+	contents := buf.String()
+	want := runProcess(t, strings.ReplaceAll(contents, "\r\n", "\n"), options{workers: 1})
+
+	// Small enough that a \r\n straddles reads
+	opts := options{workers: 2, windowSize: 1001, normalizeNewlines: true}
+	fifo := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("cannot make a FIFO: %v", err)
+	}
+	go func() {
+		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		io.WriteString(f, contents)
+	}()
+	var out bytes.Buffer
+	if err := process(&out, fifo, opts); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("stream: got %.200q, want %.200q", out.String(), want)
+	}
+
+	utf16Opts := opts
+	utf16Opts.encoding = "utf16le"
+	out.Reset()
+	if err := process(&out, writeTempFile(t, string(encodeUTF16(contents, false))), utf16Opts); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("utf16le: got %.200q, want %.200q", out.String(), want)
+	}
+
+	// A mapped file can't be normalized, so it's refused rather than read
+	// with a \r in every temperature
+	err := process(io.Discard, writeTempFile(t, contents), opts)
+	if err == nil || !strings.Contains(err.Error(), "-normalize-newlines") {
+		t.Errorf("regular file: got error %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !debug
+
+package main
+
+// checkBlockStart is a no-op outside debug builds.
+func checkBlockStart(data []byte, start int, eol byte) {}
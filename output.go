@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// formatter renders the sorted stations. begin and end are called once
+// around the stations, and station is called with each station's position
+// in the output so it can write any separator. meta, if requested, is
+// called before begin.
+type formatter interface {
+	meta(b *bufio.Writer, m runMeta)
+	begin(b *bufio.Writer)
+	station(b *bufio.Writer, i int, key []byte, stats *stats)
+	end(b *bufio.Writer)
+}
+
+// formatters maps -format names to their implementation.
+var formatters = map[string]formatter{
+	"text":       textFormat{},
+	"json":       jsonFormat{},
+	"ndjson":     ndjsonFormat{},
+	"json-array": jsonArrayFormat{},
+	"counts":     countsFormat{},
+	"gomap":      gomapFormat{},
+}
+
+// sink is one destination for the results and the format to write there.
+type sink struct {
+	w io.Writer
+	f formatter
+}
+
+// fanout formats the results to several sinks in a single pass, each
+// through its own buffer of bufSize bytes, or bufio's default size if
+// bufSize is 0. If flushEvery is set every buffer is also flushed after
+// that many stations, so a reader on a pipe sees them as they're written.
+//
+// The default stays at bufio's 4KB, which the challenge's few hundred
+// stations barely fill. A larger buffer only pays off for huge results:
+// BenchmarkWriteResultsBufferSize has 64KB nearly a fifth faster for a
+// million stations, and nothing larger doing better.
+type fanout struct {
+	sinks      []sink
+	bufs       []*bufio.Writer
+	meta       bool
+	flushEvery int
+}
+
+// metaEnder is implemented by formatters whose meta opens a structure
+// that must be closed; fanout calls endMeta in place of end for them.
+type metaEnder interface {
+	endMeta(b *bufio.Writer)
+}
+
+// newFanout starts the output of every sink, writing meta first if it's
+// non-nil.
+func newFanout(sinks []sink, bufSize, flushEvery int, meta *runMeta) *fanout {
+	o := &fanout{sinks: sinks, bufs: make([]*bufio.Writer, len(sinks)), meta: meta != nil, flushEvery: flushEvery}
+	for i, s := range sinks {
+		o.bufs[i] = bufio.NewWriterSize(s.w, bufSize)
+		if meta != nil {
+			s.f.meta(o.bufs[i], *meta)
+		}
+		s.f.begin(o.bufs[i])
+	}
+	return o
+}
+
+func (o *fanout) station(i int, key []byte, stats *stats) {
+	for j, s := range o.sinks {
+		s.f.station(o.bufs[j], i, key, stats)
+		if o.flushEvery > 0 && (i+1)%o.flushEvery == 0 {
+			o.bufs[j].Flush()
+		}
+	}
+}
+
+// finish ends every sink's output and flushes it.
+func (o *fanout) finish() error {
+	var firstErr error
+	for j, s := range o.sinks {
+		if e, ok := s.f.(metaEnder); ok && o.meta {
+			e.endMeta(o.bufs[j])
+		} else {
+			s.f.end(o.bufs[j])
+		}
+		if err := o.bufs[j].Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// writeResults formats populated to every sink.
+func writeResults(sinks []sink, populated []item, bufSize, flushEvery int, meta *runMeta) error {
+	o := newFanout(sinks, bufSize, flushEvery, meta)
+	for i, item := range populated {
+		o.station(i, item.key, item.value)
+	}
+	return o.finish()
+}
+
+// createOutputFile creates fileName for writing. If the name ends in .gz
+// what's written is gzip-compressed, and closing the file flushes and
+// closes the compressor first.
+func createOutputFile(fileName string) (io.WriteCloser, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fileName, ".gz") {
+		return f, nil
+	}
+	return gzipFile{gzip.NewWriter(f), f}, nil
+}
+
+// gzipFile is a file written through a gzip compressor.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runMeta describes a run for the -meta header.
+type runMeta struct {
+	source    string
+	rows      uint64
+	generated time.Time
+}
+
+// writeMetaComment writes m as a "# key=value ..." line.
+func writeMetaComment(b *bufio.Writer, m runMeta) {
+	fmt.Fprintf(b, "# source=%s rows=%d generated=%s\n", m.source, m.rows, m.generated.UTC().Format(time.RFC3339))
+}
+
+// textFormat is the reference 1BRC output: {a=min/mean/max, b=...}
+type textFormat struct {
+	// decimal, if set, replaces the . in each temperature, for
+	// -output-decimal-sep
+	decimal string
+
+	// showRange appends each station's max - min, for -show-range
+	showRange bool
+
+	// unit, if set, follows each temperature and is noted in a comment
+	// above the result, for -with-units
+	unit string
+}
+
+// celsiusUnit is what -with-units writes after each temperature: the
+// output is always Celsius. The degree sign is U+00B0, two bytes in UTF-8.
+const celsiusUnit = "\u00b0C"
+
+func (textFormat) meta(b *bufio.Writer, m runMeta) { writeMetaComment(b, m) }
+func (textFormat) end(b *bufio.Writer)             { b.WriteString("}\n") }
+
+func (f textFormat) begin(b *bufio.Writer) {
+	if f.unit != "" {
+		fmt.Fprintf(b, "# min/mean/max in %s\n", f.unit)
+	}
+	b.WriteByte('{')
+}
+
+func (f textFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	if i > 0 {
+		b.WriteString(", ")
+	}
+
+	var buf [64]byte
+	out := append(buf[:0], key...)
+	out = append(out, '=')
+	if stats.count == 0 {
+		// A -keep-empty station that had no rows
+		b.Write(append(out, "NA/NA/NA"...))
+		return
+	}
+	out = f.appendTenths(out, int64(stats.min))
+	out = append(out, '/')
+	out = f.appendTenths(out, stats.meanTenths())
+	out = append(out, '/')
+	out = f.appendTenths(out, int64(stats.max))
+	if stats.hist != nil {
+		// -iqr
+		out = append(out, '/')
+		out = f.appendTenths(out, stats.hist.iqrTenths())
+	}
+	if f.showRange {
+		out = append(out, '/')
+		out = f.appendTenths(out, stats.rangeTenths())
+	}
+	if e := stats.extremes; e != nil {
+		// -keep-extremes: the min and max as written
+		out = append(out, " ("...)
+		out = append(out, e.min...)
+		out = append(out, '/')
+		out = append(out, e.max...)
+		out = append(out, ')')
+	}
+	if tm := stats.times; tm != nil {
+		// -with-time: when the min and max were read
+		out = append(out, " @"...)
+		out = appendTime(out, tm.min)
+		out = append(out, '/')
+		out = appendTime(out, tm.max)
+	}
+	b.Write(out)
+}
+
+// appendTenths is appendTenths with the decimal point swapped for
+// f.decimal and followed by f.unit.
+func (f textFormat) appendTenths(dst []byte, v int64) []byte {
+	dst = appendTenths(dst, v)
+	if f.decimal != "" {
+		digit := dst[len(dst)-1]
+		dst = append(append(dst[:len(dst)-2], f.decimal...), digit)
+	}
+	return append(dst, f.unit...)
+}
+
+// jsonFormat writes a single JSON object keyed by station:
+// {"a":{"min":..,"mean":..,"max":..,"count":..},...}
+type jsonFormat struct {
+	showRange bool // add "range", for -show-range
+}
+
+// meta wraps the output as {"meta":{...},"stations":{...}} so the header
+// can't collide with a station name.
+func (jsonFormat) meta(b *bufio.Writer, m runMeta) {
+	b.WriteString(`{"meta":{"source":`)
+	writeJSONString(b, []byte(m.source))
+	fmt.Fprintf(b, `,"rows":%d,"generated":"%s"},"stations":`, m.rows, m.generated.UTC().Format(time.RFC3339))
+}
+
+func (jsonFormat) begin(b *bufio.Writer)   { b.WriteByte('{') }
+func (jsonFormat) end(b *bufio.Writer)     { b.WriteString("}\n") }
+func (jsonFormat) endMeta(b *bufio.Writer) { b.WriteString("}}\n") }
+
+func (f jsonFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	if i > 0 {
+		b.WriteByte(',')
+	}
+	writeJSONString(b, key)
+	b.WriteByte(':')
+	var buf [80]byte
+	out := append(buf[:0], '{')
+	b.Write(appendJSONFields(out, stats, f.showRange))
+	writeJSONExtremes(b, stats)
+	writeJSONTimes(b, stats)
+	b.WriteByte('}')
+}
+
+// writeJSONExtremes writes ,"min_text":..,"max_text":.. for -keep-extremes.
+func writeJSONExtremes(b *bufio.Writer, stats *stats) {
+	if stats.extremes == nil || stats.count == 0 {
+		return
+	}
+	b.WriteString(`,"min_text":`)
+	writeJSONString(b, stats.extremes.min)
+	b.WriteString(`,"max_text":`)
+	writeJSONString(b, stats.extremes.max)
+}
+
+// writeJSONTimes writes ,"min_time":..,"max_time":.. for -with-time, with
+// null for an extreme read without a timestamp.
+func writeJSONTimes(b *bufio.Writer, stats *stats) {
+	if stats.times == nil || stats.count == 0 {
+		return
+	}
+	var buf [96]byte
+	out := append(buf[:0], `,"min_time":`...)
+	out = appendJSONTime(out, stats.times.min)
+	out = append(out, `,"max_time":`...)
+	out = appendJSONTime(out, stats.times.max)
+	b.Write(out)
+}
+
+func appendJSONTime(dst []byte, t int64) []byte {
+	if t == noTime {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '"')
+	return append(appendTime(dst, t), '"')
+}
+
+// appendJSONFields appends "min":..,"mean":..,"max":..,"count":.., and
+// "iqr":.. under -iqr and "range":.. if showRange, with null temperatures
+// for a station without rows.
+func appendJSONFields(out []byte, stats *stats, showRange bool) []byte {
+	if stats.count == 0 {
+		out = append(out, `"min":null,"mean":null,"max":null,"count":0`...)
+		if showRange {
+			out = append(out, `,"range":null`...)
+		}
+		return out
+	}
+	out = append(out, `"min":`...)
+	out = appendTenths(out, int64(stats.min))
+	out = append(out, `,"mean":`...)
+	out = appendTenths(out, stats.meanTenths())
+	out = append(out, `,"max":`...)
+	out = appendTenths(out, int64(stats.max))
+	out = append(out, `,"count":`...)
+	out = strconv.AppendUint(out, stats.count, 10)
+	if stats.hist != nil {
+		out = append(out, `,"iqr":`...)
+		out = appendTenths(out, stats.hist.iqrTenths())
+	}
+	if showRange {
+		out = append(out, `,"range":`...)
+		out = appendTenths(out, stats.rangeTenths())
+	}
+	return out
+}
+
+// ndjsonFormat writes one JSON object per station per line.
+type ndjsonFormat struct {
+	showRange bool // add "range", for -show-range
+}
+
+// meta writes a leading {"meta":{...}} record, since a comment line would
+// not be valid NDJSON.
+func (ndjsonFormat) meta(b *bufio.Writer, m runMeta) {
+	b.WriteString(`{"meta":{"source":`)
+	writeJSONString(b, []byte(m.source))
+	fmt.Fprintf(b, `,"rows":%d,"generated":"%s"}}`+"\n", m.rows, m.generated.UTC().Format(time.RFC3339))
+}
+
+func (ndjsonFormat) begin(b *bufio.Writer) {}
+func (ndjsonFormat) end(b *bufio.Writer)   {}
+
+func (f ndjsonFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	writeJSONRecord(b, key, stats, f.showRange)
+	b.WriteByte('\n')
+}
+
+// writeJSONRecord writes a station as a JSON object with its name under
+// "station", as ndjson and json-array do.
+func writeJSONRecord(b *bufio.Writer, key []byte, stats *stats, showRange bool) {
+	var buf [80]byte
+	b.WriteString(`{"station":`)
+	writeJSONString(b, key)
+	out := append(buf[:0], ',')
+	b.Write(appendJSONFields(out, stats, showRange))
+	writeJSONExtremes(b, stats)
+	writeJSONTimes(b, stats)
+	b.WriteByte('}')
+}
+
+// jsonArrayFormat writes a single line JSON array of the objects ndjson
+// writes one per line: [{"station":"a","min":..,...},...]
+type jsonArrayFormat struct {
+	showRange bool // add "range", for -show-range
+}
+
+// meta wraps the output as {"meta":{...},"stations":[...]}, as jsonFormat
+// does.
+func (jsonArrayFormat) meta(b *bufio.Writer, m runMeta) { jsonFormat{}.meta(b, m) }
+
+func (jsonArrayFormat) begin(b *bufio.Writer)   { b.WriteByte('[') }
+func (jsonArrayFormat) end(b *bufio.Writer)     { b.WriteString("]\n") }
+func (jsonArrayFormat) endMeta(b *bufio.Writer) { b.WriteString("]}\n") }
+
+func (f jsonArrayFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	if i > 0 {
+		b.WriteByte(',')
+	}
+	writeJSONRecord(b, key, stats, f.showRange)
+}
+
+// meanTenths returns the mean in tenths of a degree, rounded half up as the
+// reference implementation's Math.round does. It is computed exactly in
+// integers, as floor((2*sum + count) / (2*count)), because going through
+// float64 can land just below a .x5 boundary and round the wrong way.
+// Sums or counts too big for that in int64 fall back to math/big.
+func (s *stats) meanTenths() int64 {
+	if s.count == 0 {
+		return 0
+	}
+	const safe = math.MaxInt64 / 4
+	if s.sumHi != 0 || s.sum > safe || s.sum < -safe || s.count > safe {
+		sum := new(big.Int).Lsh(big.NewInt(s.sumHi), 64)
+		sum.Add(sum, big.NewInt(s.sum))
+		twiceCount := new(big.Int).SetUint64(s.count)
+		twiceCount.Lsh(twiceCount, 1)
+		// Euclidean division floors for a positive divisor
+		num := new(big.Int).Lsh(sum, 1)
+		num.Add(num, new(big.Int).SetUint64(s.count))
+		return num.Div(num, twiceCount).Int64()
+	}
+	count := int64(s.count)
+	return floorDiv(2*s.sum+count, 2*count)
+}
+
+// rangeTenths returns max - min in tenths of a degree.
+func (s *stats) rangeTenths() int64 {
+	return int64(s.max) - int64(s.min)
+}
+
+// floorDiv divides a by a positive b, rounding toward negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// appendTenths appends v tenths of a degree with exactly one decimal place.
+func appendTenths(dst []byte, v int64) []byte {
+	if v < 0 {
+		dst = append(dst, '-')
+		v = -v
+	}
+	dst = strconv.AppendInt(dst, v/10, 10)
+	return append(dst, '.', byte('0'+v%10))
+}
+
+// countsFormat writes one station=count line per station.
+type countsFormat struct{}
+
+func (countsFormat) meta(b *bufio.Writer, m runMeta) { writeMetaComment(b, m) }
+func (countsFormat) begin(b *bufio.Writer)           {}
+func (countsFormat) end(b *bufio.Writer)             {}
+
+func (countsFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	var buf [64]byte
+	out := append(buf[:0], key...)
+	out = append(out, '=')
+	out = strconv.AppendUint(out, stats.count, 10)
+	out = append(out, '\n')
+	b.Write(out)
+}
+
+// gomapFormat writes a Go map[string]Stats composite literal with a
+// Min/Mean/Max/Count element per station, for pasting into test fixtures.
+// Stations without rows are the zero Stats.
+type gomapFormat struct{}
+
+func (gomapFormat) meta(b *bufio.Writer, m runMeta) {
+	fmt.Fprintf(b, "// source=%s rows=%d generated=%s\n", m.source, m.rows, m.generated.UTC().Format(time.RFC3339))
+}
+
+func (gomapFormat) begin(b *bufio.Writer) { b.WriteString("map[string]Stats{\n") }
+func (gomapFormat) end(b *bufio.Writer)   { b.WriteString("}\n") }
+
+func (gomapFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	var buf [128]byte
+	out := append(buf[:0], '\t')
+	out = strconv.AppendQuote(out, string(key))
+	out = append(out, ": {"...)
+	if stats.count > 0 {
+		out = append(out, "Min: "...)
+		out = appendTenths(out, int64(stats.min))
+		out = append(out, ", Mean: "...)
+		out = appendTenths(out, stats.meanTenths())
+		out = append(out, ", Max: "...)
+		out = appendTenths(out, int64(stats.max))
+		out = append(out, ", Count: "...)
+		out = strconv.AppendUint(out, stats.count, 10)
+		if stats.hist != nil {
+			out = append(out, ", IQR: "...)
+			out = appendTenths(out, stats.hist.iqrTenths())
+		}
+		if e := stats.extremes; e != nil {
+			out = append(out, ", MinText: "...)
+			out = strconv.AppendQuote(out, string(e.min))
+			out = append(out, ", MaxText: "...)
+			out = strconv.AppendQuote(out, string(e.max))
+		}
+	}
+	out = append(out, "},\n"...)
+	b.Write(out)
+}
+
+// offsetsFormat writes one station=first/last line per station, giving the
+// byte offsets of its first and last rows, for -offsets.
+type offsetsFormat struct{}
+
+func (offsetsFormat) meta(b *bufio.Writer, m runMeta) { writeMetaComment(b, m) }
+func (offsetsFormat) begin(b *bufio.Writer)           {}
+func (offsetsFormat) end(b *bufio.Writer)             {}
+
+func (offsetsFormat) station(b *bufio.Writer, i int, key []byte, stats *stats) {
+	var buf [64]byte
+	out := append(buf[:0], key...)
+	out = append(out, '=')
+	if stats.count == 0 {
+		out = append(out, "NA/NA"...)
+	} else {
+		out = strconv.AppendInt(out, stats.first, 10)
+		out = append(out, '/')
+		out = strconv.AppendInt(out, stats.last, 10)
+	}
+	out = append(out, '\n')
+	b.Write(out)
+}
+
+// writeJSONString writes s as a quoted JSON string. Invalid UTF-8 is
+// replaced with U+FFFD so the output is always valid JSON.
+func writeJSONString(b *bufio.Writer, s []byte) {
+	const hex = "0123456789abcdef"
+	b.WriteByte('"')
+	for len(s) > 0 {
+		c := s[0]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		case c < utf8.RuneSelf:
+			b.WriteByte(c)
+		default:
+			r, size := utf8.DecodeRune(s)
+			if r == utf8.RuneError && size == 1 {
+				b.WriteString("\uFFFD")
+			} else {
+				b.Write(s[:size])
+			}
+			s = s[size:]
+			continue
+		}
+		s = s[1:]
+	}
+	b.WriteByte('"')
+}
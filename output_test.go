@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestProcessNDJSON(t *testing.T) {
+	contents := "Abha;12.3\nSão \"Paulo\";-4.5\nAbha;-10.1\n"
+	got := runProcess(t, contents, options{workers: 2, format: "ndjson"})
+	want := `{"station":"Abha","min":-10.1,"mean":1.1,"max":12.3,"count":2}` + "\n" +
+		`{"station":"São \"Paulo\"","min":-4.5,"mean":-4.5,"max":-4.5,"count":1}` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProcessJSONArray(t *testing.T) {
+	contents := "b;1.0\nSão \"Paulo\"\\;-4.5\nb;3.0\ntab\there;0.0\n"
+	got := runProcess(t, contents, options{workers: 2, format: "json-array"})
+	want := `[{"station":"São \"Paulo\"\\","min":-4.5,"mean":-4.5,"max":-4.5,"count":1},` +
+		`{"station":"b","min":1.0,"mean":2.0,"max":3.0,"count":2},` +
+		`{"station":"tab\there","min":0.0,"mean":0.0,"max":0.0,"count":1}]` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var decoded []struct{ Station string }
+	if err := json.Unmarshal([]byte(got), &decoded); err != nil {
+		t.Fatalf("invalid JSON %q: %v", got, err)
+	}
+	if len(decoded) != 3 || decoded[0].Station != `São "Paulo"\` || decoded[2].Station != "tab\there" {
+		t.Errorf("decoded %+v", decoded)
+	}
+
+	if got := runProcess(t, "a;1.0\n", options{workers: 2, format: "json-array", minCount: 2}); got != "[]\n" {
+		t.Errorf("no stations: got %q, want %q", got, "[]\n")
+	}
+}
+
+func TestProcessCountsFormat(t *testing.T) {
+	got := runProcess(t, "b;1.0\na;2.0\nb;3.0\nc;4.0\nb;5.0\n", options{workers: 2, format: "counts"})
+	want := "a=1\nb=3\nc=1\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// BenchmarkWriteResultsBufferSize measures the output phase alone for a
+// million-station result written to a file, at several -write-buf sizes.
+// The median of eight runs on a 1 CPU VM:
+//
+//	bufio's 4KB default   249ms
+//	16KB                  216ms
+//	64KB                  203ms
+//	256KB                 205ms
+//	1MB                   220ms
+func BenchmarkWriteResultsBufferSize(b *testing.B) {
+	populated := make([]item, 1_000_000)
+	for i := range populated {
+		populated[i] = item{
+			key:   []byte(fmt.Sprintf("station-%07d", i)),
+			value: &stats{min: -123, max: 456, sum: int64(i), count: 100},
+		}
+	}
+	path := filepath.Join(b.TempDir(), "out.txt")
+
+	for _, size := range []int{0, 16 << 10, 64 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("write-buf=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				f, err := os.Create(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := writeResults([]sink{{f, textFormat{}}}, populated, size, 0, nil); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+func TestProcessMeta(t *testing.T) {
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	path := writeTempFile(t, "a;1.0\nb;2.0\na;3.0\n")
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "# source=" + path + " rows=3 generated=2024-01-02T03:04:05Z\n{a=1.0/2.0/3.0, b=2.0/2.0/2.0}\n"},
+		{"ndjson", `{"meta":{"source":"` + path + `","rows":3,"generated":"2024-01-02T03:04:05Z"}}` + "\n" +
+			`{"station":"a","min":1.0,"mean":2.0,"max":3.0,"count":2}` + "\n" +
+			`{"station":"b","min":2.0,"mean":2.0,"max":2.0,"count":1}` + "\n"},
+		{"json", `{"meta":{"source":"` + path + `","rows":3,"generated":"2024-01-02T03:04:05Z"},"stations":` +
+			`{"a":{"min":1.0,"mean":2.0,"max":3.0,"count":2},"b":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}}` + "\n"},
+		{"json-array", `{"meta":{"source":"` + path + `","rows":3,"generated":"2024-01-02T03:04:05Z"},"stations":` +
+			`[{"station":"a","min":1.0,"mean":2.0,"max":3.0,"count":2},{"station":"b","min":2.0,"mean":2.0,"max":2.0,"count":1}]}` + "\n"},
+	}
+	for _, tt := range tests {
+		for _, lowMem := range []bool{false, true} {
+			var out bytes.Buffer
+			if err := process(&out, path, options{workers: 2, format: tt.format, meta: true, lowMem: lowMem}); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("%s, low-mem=%v: got %q, want %q", tt.format, lowMem, out.String(), tt.want)
+			}
+		}
+	}
+}
+
+func TestProcessJSONOut(t *testing.T) {
+	path := writeTempFile(t, "b;2.0\na\"x;1.0\na\"x;3.0\n")
+	for _, lowMem := range []bool{false, true} {
+		jsonPath := filepath.Join(t.TempDir(), "out.json")
+		var out bytes.Buffer
+		if err := process(&out, path, options{workers: 2, lowMem: lowMem, jsonOut: jsonPath}); err != nil {
+			t.Fatal(err)
+		}
+		if want := "{a\"x=1.0/2.0/3.0, b=2.0/2.0/2.0}\n"; out.String() != want {
+			t.Errorf("low-mem=%v: stdout got %q, want %q", lowMem, out.String(), want)
+		}
+		got, err := os.ReadFile(jsonPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"a\"x":{"min":1.0,"mean":2.0,"max":3.0,"count":2},"b":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}` + "\n"
+		if string(got) != want {
+			t.Errorf("low-mem=%v: json got %q, want %q", lowMem, got, want)
+		}
+	}
+}
+
+func TestProcessLeaderboard(t *testing.T) {
+	// b, d and a tie on two rows and must come out by name; e ties c on one
+	contents := "d;1.0\ne;1.0\nb;1.0\nf;1.0\na;1.0\nd;1.0\nf;1.0\nb;1.0\nc;1.0\nf;1.0\na;1.0\n"
+	want := "f=3\na=2\nb=2\nd=2\nc=1\ne=1\n"
+	for _, lowMem := range []bool{false, true} {
+		got := runProcess(t, contents, options{workers: 3, format: "counts", leaderboard: true, lowMem: lowMem})
+		if got != want {
+			t.Errorf("low-mem=%v: got %q, want %q", lowMem, got, want)
+		}
+	}
+}
+
+func TestProcessGzipOut(t *testing.T) {
+	path := writeTempFile(t, "b;2.0\na;1.0\n")
+	jsonPath := filepath.Join(t.TempDir(), "out.json.gz")
+	var out bytes.Buffer
+	if err := process(&out, path, options{workers: 2, gzipOut: true, jsonOut: jsonPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	gunzip := func(r io.Reader) string {
+		t.Helper()
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got, want := gunzip(&out), "{a=1.0/1.0/1.0, b=2.0/2.0/2.0}\n"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := `{"a":{"min":1.0,"mean":1.0,"max":1.0,"count":1},"b":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}` + "\n"
+	if got := gunzip(f); got != want {
+		t.Errorf("json: got %q, want %q", got, want)
+	}
+}
+
+// writeCounter records each write it receives separately.
+type writeCounter struct {
+	writes []string
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestWriteResultsFlushEvery(t *testing.T) {
+	var populated []item
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		populated = append(populated, item{key: []byte(name), value: &stats{min: 10, max: 10, sum: 10, count: 1}})
+	}
+
+	var want bytes.Buffer
+	if err := writeResults([]sink{{&want, ndjsonFormat{}}}, populated, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flushEvery int
+		writes     int
+	}{
+		{0, 1},
+		{1, 5},
+		{2, 3},
+		{5, 1},
+	}
+	for _, tt := range tests {
+		var w writeCounter
+		if err := writeResults([]sink{{&w, ndjsonFormat{}}}, populated, 0, tt.flushEvery, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(w.writes, ""); got != want.String() {
+			t.Errorf("flushEvery=%d: got %q, want %q", tt.flushEvery, got, want.String())
+		}
+		if len(w.writes) != tt.writes {
+			t.Errorf("flushEvery=%d: got %d writes, want %d", tt.flushEvery, len(w.writes), tt.writes)
+		}
+	}
+}
+
+func TestProcessFormatGomap(t *testing.T) {
+	got := runProcess(t, "b;1.0\na \"q\";-2.5\nb;3.0\n", options{workers: 2, format: "gomap"})
+	want := "map[string]Stats{\n" +
+		"\t\"a \\\"q\\\"\": {Min: -2.5, Mean: -2.5, Max: -2.5, Count: 1},\n" +
+		"\t\"b\": {Min: 1.0, Mean: 2.0, Max: 3.0, Count: 2},\n" +
+		"}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := parser.ParseExpr(got); err != nil {
+		t.Errorf("output isn't a Go expression: %v", err)
+	}
+}
+
+func TestProcessOutputDecimalSep(t *testing.T) {
+	contents := "a;-1.5\nb;2.0\na;3.0\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{workers: 2, decimalSep: ","}, "{a=-1,5/0,8/3,0, b=2,0/2,0/2,0}\n"},
+		{options{workers: 2, decimalSep: ",", iqr: true}, "{a=-1,5/0,8/3,0/4,5, b=2,0/2,0/2,0/0,0}\n"},
+		{options{workers: 2, decimalSep: ",", format: "json"}, `{"a":{"min":-1.5,"mean":0.8,"max":3.0,"count":2},"b":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}` + "\n"},
+	}
+	for _, tt := range tests {
+		if got := runProcess(t, contents, tt.opts); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestProcessWithUnits(t *testing.T) {
+	contents := "a;-1.5\nb;2.0\na;3.0\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{workers: 2, withUnits: true}, "# min/mean/max in °C\n{a=-1.5°C/0.8°C/3.0°C, b=2.0°C/2.0°C/2.0°C}\n"},
+		{options{workers: 2, withUnits: true, decimalSep: ",", showRange: true}, "# min/mean/max in °C\n{a=-1,5°C/0,8°C/3,0°C/4,5°C, b=2,0°C/2,0°C/2,0°C/0,0°C}\n"},
+		{options{workers: 2, withUnits: true, seeds: [][]byte{[]byte("c")}, keepEmpty: true}, "# min/mean/max in °C\n{a=-1.5°C/0.8°C/3.0°C, b=2.0°C/2.0°C/2.0°C, c=NA/NA/NA}\n"},
+		{options{workers: 2, withUnits: true, format: "json"}, `{"a":{"min":-1.5,"mean":0.8,"max":3.0,"count":2},"b":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}` + "\n"},
+	}
+	for _, tt := range tests {
+		got := runProcess(t, contents, tt.opts)
+		if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%+v: output isn't valid UTF-8: %q", tt.opts, got)
+		}
+	}
+	if got := []byte(celsiusUnit); !bytes.Equal(got, []byte{0xc2, 0xb0, 'C'}) {
+		t.Errorf("celsiusUnit is % x, want c2 b0 43", got)
+	}
+}
+
+func TestProcessShowRange(t *testing.T) {
+	contents := "a;-1.5\nb;2.0\na;3.0\nc;-9.0\nc;9.0\n"
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{showRange: true}, "{a=-1.5/0.8/3.0/4.5, b=2.0/2.0/2.0/0.0, c=-9.0/0.0/9.0/18.0}\n"},
+		{options{byRange: true}, "{c=-9.0/0.0/9.0, a=-1.5/0.8/3.0, b=2.0/2.0/2.0}\n"},
+		{options{showRange: true, format: "ndjson", byRange: true}, `{"station":"c","min":-9.0,"mean":0.0,"max":9.0,"count":2,"range":18.0}` + "\n" +
+			`{"station":"a","min":-1.5,"mean":0.8,"max":3.0,"count":2,"range":4.5}` + "\n" +
+			`{"station":"b","min":2.0,"mean":2.0,"max":2.0,"count":1,"range":0.0}` + "\n"},
+		{options{byRange: true, lowMem: true}, "{c=-9.0/0.0/9.0, a=-1.5/0.8/3.0, b=2.0/2.0/2.0}\n"},
+	}
+	for _, tt := range tests {
+		tt.opts.workers = 2
+		if got := runProcess(t, contents, tt.opts); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
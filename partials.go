@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// partialTopN is how many stations -stream-partials prints per worker
+const partialTopN = 5
+
+// partialStream writes each worker's local results as it finishes, for
+// -stream-partials. It is shared by all workers.
+type partialStream struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newPartialStream(w io.Writer) *partialStream {
+	return &partialStream{w: w}
+}
+
+// report writes the row count and top stations by count of worker's
+// result on one line. Results of accumulators other than the default
+// hashtable only get the worker line.
+func (p *partialStream) report(worker int, res *chunkResult) {
+	if p == nil || p.w == nil || res == nil {
+		return
+	}
+	ht, _ := res.acc.(*hashtable)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := bufio.NewWriter(p.w)
+	fmt.Fprintf(w, "partial: worker %d done", worker)
+	if ht != nil {
+		// Seeded stations without rows aren't worth reporting
+		var top []item
+		var rows uint64
+		for _, item := range populatedItems(ht) {
+			if item.value.count > 0 {
+				top = append(top, item)
+				rows += item.value.count
+			}
+		}
+		fmt.Fprintf(w, ", %d rows in %d stations, top by count:", rows, len(top))
+
+		sortItemsBy(top, byCountDesc)
+		if len(top) > partialTopN {
+			top = top[:partialTopN]
+		}
+		for _, item := range top {
+			fmt.Fprintf(w, " %s=%d", item.key, item.value.count)
+		}
+	}
+	w.WriteByte('\n')
+	w.Flush()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProcessStreamPartials(t *testing.T) {
+	contents := "a;1.0\nb;2.0\na;3.0\nc;4.0\nc;5.0\nc;6.0\n"
+	for _, opts := range []options{{workers: 1}, {workers: 1, dispatchBatch: 1 << 20}} {
+		var diag bytes.Buffer
+		opts.partials = newPartialStream(&diag)
+		if got, want := runProcess(t, contents, opts), "{a=1.0/2.0/3.0, b=2.0/2.0/2.0, c=4.0/5.0/6.0}\n"; got != want {
+			t.Errorf("stdout: got %q, want %q", got, want)
+		}
+		want := "partial: worker 0 done, 6 rows in 3 stations, top by count: c=3 a=2 b=1\n"
+		if diag.String() != want {
+			t.Errorf("dispatch-batch %d: got %q, want %q", opts.dispatchBatch, diag.String(), want)
+		}
+	}
+
+	// Each worker reports once
+	var diag bytes.Buffer
+	runProcess(t, contents, options{workers: 3, partials: newPartialStream(&diag)})
+	if n := strings.Count(diag.String(), "partial: worker "); n != 3 {
+		t.Errorf("3 workers: got %d partial lines in %q", n, diag.String())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// processPerFile implements -per-file: each file named in paths, or found
+// directly inside a directory named there, is processed on its own and its
+// result written after a "name: " label, in order. The flags that write
+// results to a named file or directory aren't supported, since every
+// input's result would overwrite the last.
+func processPerFile(output io.Writer, paths []string, opts options) (err error) {
+	if opts.jsonOut != "" || opts.splitDir != "" {
+		return errors.New("-per-file does not support -json-out or -split-output")
+	}
+	files, err := expandPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	// Compress the whole output once rather than each file's part, which
+	// would leave the labels outside the gzip streams
+	if opts.gzipOut {
+		gz := gzip.NewWriter(output)
+		defer func() {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		output = gz
+		opts.gzipOut = false
+	}
+
+	for _, name := range files {
+		if _, err := fmt.Fprintf(output, "%s: ", name); err != nil {
+			return err
+		}
+		if err := process(output, name, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandPaths replaces each directory in paths with the regular files
+// directly inside it, sorted by name. Subdirectories aren't descended into.
+func expandPaths(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var inDir []string
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				inDir = append(inDir, filepath.Join(path, e.Name()))
+			}
+		}
+		sort.Strings(inDir)
+		files = append(files, inDir...)
+	}
+	return files, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessPerFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"b.txt": "x;2.0\n",
+		"a.txt": "x;1.0\ny;3.0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	single := writeTempFile(t, "z;-1.0\n")
+
+	var out bytes.Buffer
+	if err := processPerFile(&out, []string{single, dir}, options{workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := single + ": {z=-1.0/-1.0/-1.0}\n" +
+		filepath.Join(dir, "a.txt") + ": {x=1.0/1.0/1.0, y=3.0/3.0/3.0}\n" +
+		filepath.Join(dir, "b.txt") + ": {x=2.0/2.0/2.0}\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// Each input would overwrite the last one's file
+	for _, opts := range []options{
+		{workers: 2, jsonOut: filepath.Join(t.TempDir(), "out.json")},
+		{workers: 2, splitDir: t.TempDir()},
+	} {
+		if err := processPerFile(io.Discard, []string{single, dir}, opts); err == nil {
+			t.Errorf("%+v: got no error", opts)
+		}
+		if opts.jsonOut != "" {
+			if _, err := os.Stat(opts.jsonOut); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("-json-out was written: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+// recordVerdict is what fieldParser.parse made of one record.
+type recordVerdict int
+
+const (
+	recordAccepted recordVerdict = iota
+
+	// recordDropped is a record left out on purpose rather than for being
+	// malformed: an -exclude'd station or, when not validating, a
+	// temperature too short to parse
+	recordDropped
+
+	recordMalformed
+)
+
+// record is what one accepted record contributes to the results.
+type record struct {
+	key     []byte
+	hash    fnvHash
+	temp    int32  // in tenths of a degree Celsius
+	text    []byte // the temperature as written, for -keep-extremes
+	at      int64  // when the row was read, for -with-time, or noTime
+	clamped bool   // -clamp moved temp to a bound
+}
+
+// fieldParser turns the station and temperature fields of a record into
+// what it contributes, applying every per-record flag: the station's
+// trimming, collapsing, grouping and exclusion, then the temperature's
+// timestamp and unit, its parse, -clamp and the conversion to Celsius.
+// processData, processFixedData and checkData each split lines into fields
+// their own way and share this for the rest, so -check accepts exactly the
+// records a -strict run does. It is per worker, as the collapser is.
+type fieldParser struct {
+	validating bool
+	strict     bool
+	validate   func([]byte) (int32, bool)
+
+	trimKeys    bool
+	collapser   *spaceCollapser
+	groupPrefix int
+	excluded    *stationSet
+
+	withTime, epoch      bool
+	delim                []byte
+	parseUnit            bool
+	fahrenheit           bool
+	general, sci, tenths bool
+	clamp                *clampRange
+}
+
+// newFieldParser returns a parser for the records of opts. validating
+// checks each temperature as -strict does, which -stats and -fail-fast
+// need too, rather than parsing it on the assumption it's well formed.
+func newFieldParser(opts *options, validating bool) *fieldParser {
+	p := &fieldParser{
+		validating:  validating,
+		strict:      opts.strict,
+		validate:    opts.tempValidator(),
+		trimKeys:    opts.trimKeys,
+		groupPrefix: opts.groupPrefix,
+		excluded:    opts.excluded,
+		withTime:    opts.withTime,
+		epoch:       opts.epochTime,
+		delim:       opts.delimiter,
+		parseUnit:   opts.parseUnit,
+		fahrenheit:  opts.fahrenheit,
+		general:     opts.generalParse,
+		sci:         opts.sciNotation,
+		tenths:      opts.tenths,
+		clamp:       opts.clamp,
+	}
+	if opts.collapseSpace {
+		p.collapser = newSpaceCollapser()
+	}
+	return p
+}
+
+// parse fills r from the station and value fields of one record, value
+// being everything after the station's delimiter. It returns the verdict
+// and, for a malformed record, why. Only a validating parser finds a
+// record malformed, and then only a -strict one for being outside -clamp.
+func (p *fieldParser) parse(station, value []byte, r *record) (recordVerdict, skipReason) {
+	if p.trimKeys {
+		for len(station) > 0 && isASCIISpace(station[len(station)-1]) {
+			station = station[:len(station)-1]
+		}
+	}
+	if p.collapser != nil {
+		station = p.collapser.collapse(station)
+	}
+	if p.groupPrefix > 0 {
+		station = station[:groupLen(station, p.groupPrefix)]
+	}
+	r.key = station
+	r.hash = hashBytes(station, 0, len(station))
+	if p.excluded != nil && p.excluded.contains(r.hash, station) {
+		return recordDropped, 0
+	}
+
+	r.at = noTime
+	if p.withTime {
+		value, r.at = splitTime(value, p.delim, p.epoch)
+	}
+	fahrenheit := p.fahrenheit
+	if p.parseUnit {
+		value, fahrenheit = splitUnit(value, fahrenheit)
+	}
+	r.text = value
+
+	if p.validating {
+		t, ok := p.validate(value)
+		if !ok {
+			return recordMalformed, skipBadTemp
+		}
+		if len(station) == 0 {
+			return recordMalformed, skipNoStation
+		}
+		r.temp = t
+	} else {
+		if len(value) == 0 || loneSign(value) {
+			// An empty temperature is only possible with -reverse-fields,
+			// where it's the field the delimiter scan doesn't guarantee
+			// is there; a lone sign is left by a line truncated after it
+			return recordDropped, 0
+		}
+		switch {
+		case p.tenths:
+			r.temp = tenthsToFixedPointInt(value)
+		case p.sci:
+			r.temp, _ = parseTempSci(value)
+		case p.general:
+			r.temp, _ = parseTempGeneral(value)
+		default:
+			r.temp = bytesToFixedPointInt(value)
+		}
+	}
+
+	r.clamped = false
+	if p.clamp != nil && !p.clamp.contains(r.temp) {
+		if p.strict {
+			return recordMalformed, skipOutOfRange
+		}
+		r.temp = p.clamp.apply(r.temp)
+		r.clamped = true
+	}
+	if fahrenheit {
+		r.temp = fahrenheitToCelsius(r.temp)
+	}
+	return recordAccepted, 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// loadRenames reads a -rename file of "from\tto" lines. Blank lines are
+// ignored.
+func loadRenames(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	renames := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+		from, to, ok := bytes.Cut(line, []byte{'\t'})
+		if !ok || len(from) == 0 || len(to) == 0 {
+			return nil, fmt.Errorf("%s:%d: want \"from\\tto\", got %q", fileName, lineNo, line)
+		}
+		renames[string(from)] = string(to)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return renames, nil
+}
+
+// applyRenames returns a table where every station named in renames has
+// been folded into its new name, combining the stats if the new name also
+// has rows of its own. Renames are applied once, not chained.
+func applyRenames(ht *hashtable, renames map[string]string) *hashtable {
+	res := NewHashTable(uint64(len(ht.items)))
+	for _, item := range ht.items {
+		if item.value == nil {
+			continue
+		}
+
+		key, hash := item.key, item.hash
+		if to, ok := renames[string(key)]; ok {
+			key = []byte(to)
+			hash = hashBytes(key, 0, len(key))
+		}
+
+		s := res.get(hash, key)
+		if s == nil {
+			v := *item.value
+			res.add(hash, key, &v)
+		} else {
+			s.merge(item.value)
+		}
+	}
+	return res
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessRename(t *testing.T) {
+	renameFile := filepath.Join(t.TempDir(), "renames.txt")
+	if err := os.WriteFile(renameFile, []byte("NYC\tNew York\nLA\tLos Angeles\n\nBos\tBoston\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	renames, err := loadRenames(renameFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NYC and New York both exist and combine; LA only exists under its
+	// alias; Bos never appears
+	contents := "NYC;10.0\nNew York;20.0\nLA;30.0\nNYC;-5.0\nChicago;1.0\n"
+	got := runProcess(t, contents, options{workers: 2, renames: renames})
+	want := "{Chicago=1.0/1.0/1.0, Los Angeles=30.0/30.0/30.0, New York=-5.0/8.3/20.0}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadRenamesRejectsMalformedLines(t *testing.T) {
+	renameFile := filepath.Join(t.TempDir(), "renames.txt")
+	if err := os.WriteFile(renameFile, []byte("NYC\tNew York\nno tab here\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRenames(renameFile); err == nil {
+		t.Error("expected an error for a line without a tab")
+	}
+}
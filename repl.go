@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `commands:
+  NAME            the stats of station NAME
+  top [N] [BY]    the N highest stations (default 10) by count, mean, min, max or range (default mean)
+  prefix X        every station whose name starts with X
+  help            this text
+  quit            leave
+`
+
+// repl implements -repl: it aggregates fileName once, as -serve does, and
+// then answers queries typed at a prompt on in until it ends or the user
+// quits, writing results to out in the selected -format.
+func repl(in io.Reader, out io.Writer, fileName string, opts options) error {
+	s, err := newStatsServer(fileName, opts)
+	if err != nil {
+		return err
+	}
+	f := opts.formatter()
+	fmt.Fprintf(out, "%d stations; type help for commands\n", len(s.items))
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		items, msg := s.query(line)
+		if msg != "" {
+			fmt.Fprintln(out, msg)
+			continue
+		}
+		if err := writeResults([]sink{{out, f}}, items, 0, 0, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// query answers one -repl line with the stations to print, or a message
+// to show instead. Commands are matched before station names, so a
+// station called help or top can't be looked up by name.
+func (s *statsServer) query(line string) ([]item, string) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "help":
+		return nil, strings.TrimSuffix(replHelp, "\n")
+	case "top":
+		return s.top(strings.Fields(arg))
+	case "prefix":
+		if arg == "" {
+			return nil, "usage: prefix X"
+		}
+		// items are sorted by name, so the matches are consecutive
+		from := sort.Search(len(s.items), func(i int) bool { return bytes.Compare(s.items[i].key, []byte(arg)) >= 0 })
+		to := from
+		for to < len(s.items) && bytes.HasPrefix(s.items[to].key, []byte(arg)) {
+			to++
+		}
+		if from == to {
+			return nil, fmt.Sprintf("no stations start with %q", arg)
+		}
+		return s.items[from:to], ""
+	}
+
+	i, ok := s.index[line]
+	if !ok {
+		return nil, fmt.Sprintf("unknown station %q; type help for commands", line)
+	}
+	return s.items[i : i+1], ""
+}
+
+// top parses top's arguments, [N] [BY], and ranks the stations by them.
+func (s *statsServer) top(args []string) ([]item, string) {
+	n, by := defaultTopN, "mean"
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil {
+			if v < 1 {
+				return nil, fmt.Sprintf("N must be a positive number, got %d", v)
+			}
+			n, args = v, args[1:]
+		}
+	}
+	if len(args) > 0 {
+		by, args = args[0], args[1:]
+	}
+	less, ok := topOrders[by]
+	if !ok || len(args) > 0 {
+		return nil, "usage: top [N] [count|mean|min|max|range]"
+	}
+	return s.highest(n, less), ""
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	path := writeTempFile(t, "Bonn;1.0\nAbha;5.0\nBaku;3.0\nBonn;-3.0\nAccra;2.0\n")
+	in := strings.NewReader("Bonn\n\ntop 2\ntop 1 min\nprefix B\nprefix Z\nnowhere\ntop 0\nquit\nAbha\n")
+	var out bytes.Buffer
+	if err := repl(in, &out, path, options{workers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := "4 stations; type help for commands\n" +
+		"> {Bonn=-3.0/-1.0/1.0}\n" +
+		"> > {Abha=5.0/5.0/5.0, Baku=3.0/3.0/3.0}\n" +
+		"> {Abha=5.0/5.0/5.0}\n" +
+		"> {Baku=3.0/3.0/3.0, Bonn=-3.0/-1.0/1.0}\n" +
+		"> no stations start with \"Z\"\n" +
+		"> unknown station \"nowhere\"; type help for commands\n" +
+		"> N must be a positive number, got 0\n" +
+		"> "
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// The end of input ends the session too, in another format
+	out.Reset()
+	if err := repl(strings.NewReader("Abha\n"), &out, path, options{workers: 1, format: "ndjson"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"station":"Abha","min":5.0,"mean":5.0,"max":5.0,"count":1}`; !strings.Contains(out.String(), want) || !strings.HasSuffix(out.String(), "> \n") {
+		t.Errorf("got %q", out.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// reservoir is a uniform random sample of up to k of the temperatures of
+// one station, out of the seen rows it has had.
+type reservoir struct {
+	seen  uint64
+	temps []int32
+}
+
+// sampleAccumulator keeps a reservoir of up to k temperatures per station,
+// for -samples. Each worker samples its block with Algorithm R and the
+// merge combines reservoirs weighted by the rows behind them, so every
+// row of a station is equally likely to be among its samples whatever the
+// worker count.
+type sampleAccumulator struct {
+	k        int
+	rng      *rand.Rand
+	stations map[string]*reservoir
+}
+
+func newSampleAccumulator(k int) *sampleAccumulator {
+	return &sampleAccumulator{
+		k:        k,
+		rng:      rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		stations: make(map[string]*reservoir),
+	}
+}
+
+func (a *sampleAccumulator) Update(key []byte, hash uint64, temp int32) {
+	r := a.stations[string(key)]
+	if r == nil {
+		r = &reservoir{temps: make([]int32, 0, a.k)}
+		a.stations[string(key)] = r
+	}
+	r.seen++
+	if len(r.temps) < a.k {
+		r.temps = append(r.temps, temp)
+	} else if j := a.rng.Uint64N(r.seen); j < uint64(a.k) {
+		r.temps[j] = temp
+	}
+}
+
+// Merge combines each of other's reservoirs with the receiver's by drawing
+// the k samples one at a time from either side, with odds in proportion to
+// the rows that side has yet to be drawn from.
+func (a *sampleAccumulator) Merge(other Accumulator) {
+	for key, o := range other.(*sampleAccumulator).stations {
+		r := a.stations[key]
+		if r == nil {
+			a.stations[key] = &reservoir{seen: o.seen, temps: slices.Clone(o.temps)}
+			continue
+		}
+
+		mine, theirs := slices.Clone(r.temps), slices.Clone(o.temps)
+		left, right := r.seen, o.seen
+		merged := make([]int32, 0, a.k)
+		for len(merged) < a.k && (len(mine) > 0 || len(theirs) > 0) {
+			from := &theirs
+			if len(theirs) == 0 || len(mine) > 0 && a.rng.Uint64N(left+right) < left {
+				from = &mine
+				left--
+			} else {
+				right--
+			}
+			i := a.rng.IntN(len(*from))
+			merged = append(merged, (*from)[i])
+			(*from)[i] = (*from)[len(*from)-1]
+			*from = (*from)[:len(*from)-1]
+		}
+		r.seen += o.seen
+		r.temps = merged
+	}
+}
+
+// WriteTo writes one station=t1,t2,... line per station in name order,
+// with each station's samples in ascending order.
+func (a *sampleAccumulator) WriteTo(w io.Writer) (int64, error) {
+	keys := make([]string, 0, len(a.stations))
+	for key := range a.stations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	for _, key := range keys {
+		temps := a.stations[key].temps
+		slices.Sort(temps)
+		out = append(out, key...)
+		out = append(out, '=')
+		for i, t := range temps {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = appendTenths(out, int64(t))
+		}
+		out = append(out, '\n')
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessSamples(t *testing.T) {
+	opts := options{workers: 3, accumulator: func(uint64) Accumulator { return newSampleAccumulator(4) }}
+	got := runProcess(t, "b;1.0\na;-2.5\nb;3.0\nb;2.0\na;0.0\n", opts)
+	if want := "a=-2.5,0.0\nb=1.0,2.0,3.0\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestSampleMergeWeighted checks that merging a reservoir of 90 rows with
+// one of 10 picks from the second about a tenth of the time, rather than
+// half as an unweighted merge would.
+func TestSampleMergeWeighted(t *testing.T) {
+	const trials = 2000
+	fromSmall := 0
+	for i := 0; i < trials; i++ {
+		big, small := newSampleAccumulator(1), newSampleAccumulator(1)
+		for j := 0; j < 90; j++ {
+			big.Update([]byte("a"), 0, 10)
+		}
+		for j := 0; j < 10; j++ {
+			small.Update([]byte("a"), 0, 20)
+		}
+		big.Merge(small)
+		r := big.stations["a"]
+		if r.seen != 100 || len(r.temps) != 1 {
+			t.Fatalf("got %d rows and %d samples, want 100 and 1", r.seen, len(r.temps))
+		}
+		if r.temps[0] == 20 {
+			fromSmall++
+		}
+	}
+	if frac := float64(fromSmall) / trials; frac < 0.06 || frac > 0.14 {
+		t.Errorf("got %.3f of samples from the smaller reservoir, want about 0.1", frac)
+	}
+}
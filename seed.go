@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// maxReportedUnexpected is how many unexpected stations -strict prints
+// when -seed-stations is set
+const maxReportedUnexpected = 10
+
+// loadSeedStations reads a -seed-stations file of one station name per
+// line. Blank lines are ignored.
+func loadSeedStations(fileName string) ([][]byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seeds [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte{'\r'})
+		if len(line) == 0 {
+			continue
+		}
+		seeds = append(seeds, bytes.Clone(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
+
+// seed adds every key to ht with empty stats. The empty stats hold the
+// identities for min, max and the offsets, so rows update and merge into
+// them as if the station had been created by its first row.
+func (ht *hashtable) seed(keys [][]byte) {
+	for _, key := range keys {
+		hash := hashBytes(key, 0, len(key))
+		if ht.get(hash, key) == nil {
+			ht.add(hash, key, &stats{min: math.MaxInt32, max: math.MinInt32, first: math.MaxInt64, last: -1})
+		}
+	}
+}
+
+// settleSeeds returns ht without the seeded stations that had no rows or,
+// with keepEmpty, with zeroed stats in their place, which the formatters
+// print as a sentinel: NA/NA/NA in text and nulls in JSON. ht itself is
+// left as is, so a table that is still merged into, as under -follow,
+// keeps the empty stats' identities for the rows still to come.
+func settleSeeds(ht *hashtable, keepEmpty bool) *hashtable {
+	res := NewHashTable(uint64(len(ht.items)))
+	for _, item := range ht.items {
+		switch {
+		case item.value == nil:
+		case item.value.count > 0:
+			res.add(item.hash, item.key, item.value)
+		case keepEmpty:
+			res.add(item.hash, item.key, &stats{})
+		}
+	}
+	return res
+}
+
+// reportUnexpected writes the first stations in ht that aren't in seeds to
+// w and returns an error if there were any.
+func reportUnexpected(w io.Writer, ht *hashtable, seeds [][]byte) error {
+	known := make(map[string]struct{}, len(seeds))
+	for _, key := range seeds {
+		known[string(key)] = struct{}{}
+	}
+
+	var unexpected []item
+	for _, item := range ht.items {
+		if item.value == nil {
+			continue
+		}
+		if _, ok := known[string(item.key)]; !ok {
+			unexpected = append(unexpected, item)
+		}
+	}
+	if len(unexpected) == 0 {
+		return nil
+	}
+
+	sortItems(unexpected)
+	if w != nil {
+		for i, item := range unexpected {
+			if i == maxReportedUnexpected {
+				break
+			}
+			fmt.Fprintf(w, "unexpected station %q: %d rows\n", item.key, item.value.count)
+		}
+	}
+	return fmt.Errorf("%d unexpected stations", len(unexpected))
+}
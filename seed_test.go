@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessSeedStations(t *testing.T) {
+	seedFile := filepath.Join(t.TempDir(), "seeds.txt")
+	if err := os.WriteFile(seedFile, []byte("a\nb\n\nc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	seeds, err := loadSeedStations(seedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c is seeded but has no rows
+	contents := "a;-1.0\nb;2.0\na;3.0\n"
+	for _, opts := range []options{
+		{workers: 2},
+		{workers: 2, lowMem: true},
+		{workers: 2, windowSize: 4096},
+		{workers: 2, strict: true},
+	} {
+		opts.seeds = seeds
+		if got, want := runProcess(t, contents, opts), "{a=-1.0/1.0/3.0, b=2.0/2.0/2.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+		opts.keepEmpty = true
+		if got, want := runProcess(t, contents, opts), "{a=-1.0/1.0/3.0, b=2.0/2.0/2.0, c=NA/NA/NA}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	var diag bytes.Buffer
+	err = process(io.Discard, writeTempFile(t, contents+"x;1.0\nx;2.0\n"), options{workers: 2, strict: true, seeds: seeds, diag: &diag})
+	if err == nil || err.Error() != "1 unexpected stations" {
+		t.Errorf("got %v, want 1 unexpected stations", err)
+	}
+	if want := "unexpected station \"x\": 2 rows\n"; diag.String() != want {
+		t.Errorf("diag: got %q, want %q", diag.String(), want)
+	}
+}
+
+func TestProcessKeepEmptySentinel(t *testing.T) {
+	seeds := [][]byte{[]byte("a"), []byte("z")}
+	contents := "a;1.0\n"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "{a=1.0/1.0/1.0, z=NA/NA/NA}\n"},
+		{"json", `{"a":{"min":1.0,"mean":1.0,"max":1.0,"count":1},"z":{"min":null,"mean":null,"max":null,"count":0}}` + "\n"},
+		{"ndjson", `{"station":"a","min":1.0,"mean":1.0,"max":1.0,"count":1}` + "\n" +
+			`{"station":"z","min":null,"mean":null,"max":null,"count":0}` + "\n"},
+		{"counts", "a=1\nz=0\n"},
+	}
+	for _, tt := range tests {
+		got := runProcess(t, contents, options{workers: 1, format: tt.format, seeds: seeds, keepEmpty: true})
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	got := runProcess(t, contents, options{workers: 1, offsets: true, seeds: seeds, keepEmpty: true})
+	if want := "a=0/0\nz=NA/NA\n"; got != want {
+		t.Errorf("offsets: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// defaultTopN is how many stations /top returns without an n parameter
+const defaultTopN = 10
+
+// topOrders are the rankings /top accepts for its by parameter, each
+// highest first with ties broken by name.
+var topOrders = map[string]stationLess{
+	"count": byCountDesc,
+	"mean":  descBy(func(s *stats) int64 { return s.meanTenths() }),
+	"min":   descBy(func(s *stats) int64 { return int64(s.min) }),
+	"max":   descBy(func(s *stats) int64 { return int64(s.max) }),
+	"range": byRangeDesc,
+}
+
+// descBy orders stations by value, highest first, breaking ties by name.
+func descBy(value func(*stats) int64) stationLess {
+	return func(aKey []byte, a *stats, bKey []byte, b *stats) bool {
+		if av, bv := value(a), value(b); av != bv {
+			return av > bv
+		}
+		return bytes.Compare(aKey, bKey) < 0
+	}
+}
+
+// statsServer serves a copy of one run's results for -serve:
+//
+//	GET /stations          every station, as a JSON object keyed by name
+//	GET /station/{name}    one station, in the same shape
+//	GET /top?n=10&by=mean  the n highest stations by count, mean, min or
+//	                       max, as NDJSON so the ranking order is kept
+type statsServer struct {
+	items []item // sorted by name
+	index map[string]int
+}
+
+// newStatsServer aggregates fileName with opts and keeps the results.
+func newStatsServer(fileName string, opts options) (*statsServer, error) {
+	items, err := aggregateItems(fileName, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsServer{items: items, index: make(map[string]int, len(items))}
+	for i, item := range s.items {
+		s.index[string(item.key)] = i
+	}
+	return s, nil
+}
+
+func (s *statsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stations", s.handleStations)
+	mux.HandleFunc("GET /station/{name...}", s.handleStation)
+	mux.HandleFunc("GET /top", s.handleTop)
+	return mux
+}
+
+func (s *statsServer) handleStations(w http.ResponseWriter, r *http.Request) {
+	writeItems(w, "application/json", jsonFormat{}, s.items)
+}
+
+func (s *statsServer) handleStation(w http.ResponseWriter, r *http.Request) {
+	i, ok := s.index[r.PathValue("name")]
+	if !ok {
+		http.Error(w, "unknown station", http.StatusNotFound)
+		return
+	}
+	writeItems(w, "application/json", jsonFormat{}, s.items[i:i+1])
+}
+
+func (s *statsServer) handleTop(w http.ResponseWriter, r *http.Request) {
+	n := defaultTopN
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("n must be a positive number, got %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "mean"
+	}
+	less, ok := topOrders[by]
+	if !ok {
+		http.Error(w, fmt.Sprintf("by must be count, mean, min, max or range, got %q", by), http.StatusBadRequest)
+		return
+	}
+
+	writeItems(w, "application/x-ndjson", ndjsonFormat{}, s.highest(n, less))
+}
+
+// highest returns the first n stations ranked by less.
+func (s *statsServer) highest(n int, less stationLess) []item {
+	top := append([]item(nil), s.items...)
+	sortItemsBy(top, less)
+	if n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// writeItems formats items as the response body. A write error means the
+// client has gone, so there's no one left to report it to.
+func writeItems(w http.ResponseWriter, contentType string, f formatter, items []item) {
+	w.Header().Set("Content-Type", contentType)
+	writeResults([]sink{{w, f}}, items, 0, 0, nil)
+}
+
+// serve implements -serve: it aggregates fileName once and then answers
+// queries about the results on addr until the server fails.
+func serve(addr, fileName string, opts options) error {
+	s, err := newStatsServer(fileName, opts)
+	if err != nil {
+		return err
+	}
+	opts.logf("serving %d stations on %s", len(s.items), addr)
+	return http.ListenAndServe(addr, s.handler())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsServer(t *testing.T) {
+	path := writeTempFile(t, "b;5.0\na/x;1.0\nc;-3.0\nb;-1.0\nc;9.0\nd;2.0\n")
+	s, err := newStatsServer(path, options{workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.handler()
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/stations", http.StatusOK, `{"a/x":{"min":1.0,"mean":1.0,"max":1.0,"count":1},"b":{"min":-1.0,"mean":2.0,"max":5.0,"count":2},` +
+			`"c":{"min":-3.0,"mean":3.0,"max":9.0,"count":2},"d":{"min":2.0,"mean":2.0,"max":2.0,"count":1}}` + "\n"},
+		{"/station/a%2Fx", http.StatusOK, `{"a/x":{"min":1.0,"mean":1.0,"max":1.0,"count":1}}` + "\n"},
+		{"/station/a/x", http.StatusOK, `{"a/x":{"min":1.0,"mean":1.0,"max":1.0,"count":1}}` + "\n"},
+		{"/station/zzz", http.StatusNotFound, "unknown station\n"},
+		// b and d tie on a mean of 2.0 and are ordered by name
+		{"/top?n=3", http.StatusOK, `{"station":"c","min":-3.0,"mean":3.0,"max":9.0,"count":2}` + "\n" +
+			`{"station":"b","min":-1.0,"mean":2.0,"max":5.0,"count":2}` + "\n" +
+			`{"station":"d","min":2.0,"mean":2.0,"max":2.0,"count":1}` + "\n"},
+		{"/top?n=1&by=min", http.StatusOK, `{"station":"d","min":2.0,"mean":2.0,"max":2.0,"count":1}` + "\n"},
+		{"/top?n=0", http.StatusBadRequest, "n must be a positive number, got \"0\"\n"},
+		{"/top?by=median", http.StatusBadRequest, "by must be count, mean, min, max or range, got \"median\"\n"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code || rec.Body.String() != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, rec.Code, rec.Body.String(), tt.code, tt.want)
+		}
+	}
+}
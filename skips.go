@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// skipReason is why a malformed line was left out of the results.
+type skipReason int
+
+const (
+	skipEmpty skipReason = iota
+	skipNoDelimiter
+	skipTooShort // -fixed lines that end before the temperature column
+	skipBadTemp
+	skipNoStation
+	skipOutOfRange // outside -clamp, under -strict
+	numSkipReasons
+)
+
+var skipReasonNames = [numSkipReasons]string{
+	skipEmpty:       "empty line",
+	skipNoDelimiter: "no delimiter",
+	skipTooShort:    "too short for the fixed layout",
+	skipBadTemp:     "unparseable temperature",
+	skipNoStation:   "empty station",
+	skipOutOfRange:  "temperature outside -clamp",
+}
+
+// skipCounts counts the malformed lines skipped for each reason under
+// -stats.
+type skipCounts [numSkipReasons]int
+
+func (c *skipCounts) merge(other skipCounts) {
+	for i, n := range other {
+		c[i] += n
+	}
+}
+
+// skip records the line data[lineStart:lineEnd] as malformed for why and,
+// in strict mode, also rejects it. Under -fail-fast it is also offered as
+// the first failure.
+func (res *chunkResult) skip(why skipReason, data []byte, lineStart, lineEnd int, strict bool) {
+	res.skipped[why]++
+	if strict {
+		res.rejected.add(data, lineStart, lineEnd)
+	}
+	if res.failure != nil {
+		res.failure.record(data, lineStart, lineEnd)
+	}
+}
+
+// totalSkips adds up the skip counts of all workers.
+func totalSkips(results []*chunkResult) skipCounts {
+	var total skipCounts
+	for _, r := range results {
+		total.merge(r.skipped)
+	}
+	return total
+}
+
+// reportSkips writes the breakdown of skipped lines to w, listing only the
+// reasons that occurred.
+func reportSkips(w io.Writer, c skipCounts) {
+	if w == nil {
+		return
+	}
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	fmt.Fprintf(w, "skipped %d malformed lines\n", total)
+	for why, n := range c {
+		if n > 0 {
+			fmt.Fprintf(w, "  %s: %d\n", skipReasonNames[why], n)
+		}
+	}
+}
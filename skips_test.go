@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestProcessSkipStats(t *testing.T) {
+	contents := "a;1.0\n\nnodelim\nb;x.y\n;2.0\nb;3.0\n\nc;1"
+	want := "skipped 6 malformed lines\n" +
+		"  empty line: 2\n" +
+		"  no delimiter: 1\n" +
+		"  unparseable temperature: 2\n" +
+		"  empty station: 1\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 2, windowSize: 4096},
+	} {
+		var out, diag bytes.Buffer
+		opts.stats = true
+		opts.diag = &diag
+		if err := process(&out, writeTempFile(t, contents), opts); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := out.String(), "{a=1.0/1.0/1.0, b=3.0/3.0/3.0}\n"; got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+		if diag.String() != want {
+			t.Errorf("%+v: diag got %q, want %q", opts, diag.String(), want)
+		}
+	}
+
+	var diag bytes.Buffer
+	fixed := "Abha   12.3\n\nAb\n       1.0\nBeirut x\n"
+	opts := options{workers: 1, stats: true, diag: &diag, fixed: &fixedLayout{station: 7, temp: 5}}
+	if err := process(io.Discard, writeTempFile(t, fixed), opts); err != nil {
+		t.Fatal(err)
+	}
+	wantFixed := "skipped 4 malformed lines\n" +
+		"  empty line: 1\n" +
+		"  too short for the fixed layout: 1\n" +
+		"  unparseable temperature: 1\n" +
+		"  empty station: 1\n"
+	if diag.String() != wantFixed {
+		t.Errorf("fixed: diag got %q, want %q", diag.String(), wantFixed)
+	}
+}
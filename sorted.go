@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// sortedRuns aggregates input whose rows are grouped by station, for
+// -sorted-input. Only the current station's stats are live, so each row
+// is compared with the previous row's station rather than looked up, and a
+// change of station starts a new run. The workers' blocks are consecutive,
+// so merging them only has to join a station split across a boundary.
+//
+// Input that isn't grouped after all still aggregates correctly, as a
+// station back for a second run is merged with its first one when the
+// output is built, just more slowly. With verify the first station out of
+// order is reported as an error instead.
+type sortedRuns struct {
+	runs   []item
+	verify bool
+	err    error
+}
+
+func newSortedRuns(verify bool) *sortedRuns {
+	return &sortedRuns{verify: verify}
+}
+
+func (r *sortedRuns) Update(key []byte, hash uint64, temp int32) {
+	if n := len(r.runs); n > 0 && r.runs[n-1].matches(hash, key) {
+		s := r.runs[n-1].value
+		if temp < s.min {
+			s.min = temp
+		}
+		if temp > s.max {
+			s.max = temp
+		}
+		s.sum += int64(temp)
+		s.count++
+		return
+	}
+	r.start(item{hash: hash, key: key, value: &stats{min: temp, max: temp, sum: int64(temp), count: 1}})
+}
+
+// start appends a run for a station other than the current one, checking
+// under verify that it sorts after it.
+func (r *sortedRuns) start(run item) {
+	if n := len(r.runs); r.verify && r.err == nil && n > 0 && bytes.Compare(r.runs[n-1].key, run.key) > 0 {
+		r.err = unsortedError(run.key, r.runs[n-1].key)
+	}
+	r.runs = append(r.runs, run)
+}
+
+func unsortedError(key, prev []byte) error {
+	return fmt.Errorf("input isn't sorted by station: %q follows %q", key, prev)
+}
+
+// Merge appends the runs of other, which must come from the input right
+// after the receiver's.
+func (r *sortedRuns) Merge(other Accumulator) {
+	o := other.(*sortedRuns)
+	if r.err == nil {
+		r.err = o.err
+	}
+	for i, run := range o.runs {
+		if n := len(r.runs); i == 0 && n > 0 && r.runs[n-1].matches(run.hash, run.key) {
+			r.runs[n-1].value.merge(run.value)
+			continue
+		}
+		v := *run.value
+		run.value = &v
+		r.start(run)
+	}
+}
+
+// follows returns the error for the runs not following on from prev, the
+// last station of the input before them, under verify. The ranged paths
+// use it across their ranges, as Merge does across the workers' blocks.
+func (r *sortedRuns) follows(prev []byte) error {
+	if r.err != nil || !r.verify || prev == nil || len(r.runs) == 0 {
+		return r.err
+	}
+	if bytes.Compare(prev, r.runs[0].key) > 0 {
+		return unsortedError(r.runs[0].key, prev)
+	}
+	return nil
+}
+
+// table returns the runs as a hashtable for the formatters, merging any
+// station that had more than one.
+func (r *sortedRuns) table() *hashtable {
+	ht := NewHashTable(2*uint64(len(r.runs)) + 1)
+	for _, run := range r.runs {
+		if s := ht.get(run.hash, run.key); s != nil {
+			s.merge(run.value)
+		} else {
+			ht.add(run.hash, run.key, run.value)
+		}
+	}
+	return ht
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// BenchmarkProcessSortedInput compares the hashtable with -sorted-input on
+// the 413-station distribution sorted by station.
+func BenchmarkProcessSortedInput(b *testing.B) {
+	data, err := os.ReadFile(writeBenchFile(b, 1_000_000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i][:strings.IndexByte(lines[i], ';')+1] < lines[j][:strings.IndexByte(lines[j], ';')+1]
+	})
+	path := filepath.Join(b.TempDir(), "sorted.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, sorted := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sorted-input=%v", sorted), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := process(io.Discard, path, options{workers: runtime.NumCPU(), sortedInput: sorted}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessSortedInput(t *testing.T) {
+	sorted := "a;1.0\na;3.0\nb;-2.0\nb;4.0\nb;1.0\nc;0.5\nc;1.5\nd;9.9\n"
+	want := runProcess(t, sorted, options{workers: 1})
+	for workers := 1; workers <= 4; workers++ {
+		if got := runProcess(t, sorted, options{workers: workers, verifySorted: true, sortedInput: true}); got != want {
+			t.Errorf("workers=%d: got %q, want %q", workers, got, want)
+		}
+	}
+
+	// Out of order rows still aggregate right unless they're verified
+	unsorted := "b;-2.0\na;1.0\nb;4.0\nc;0.5\na;3.0\nd;9.9\nb;1.0\nc;1.5\n"
+	for workers := 1; workers <= 4; workers++ {
+		if got := runProcess(t, unsorted, options{workers: workers, sortedInput: true}); got != want {
+			t.Errorf("unsorted, workers=%d: got %q, want %q", workers, got, want)
+		}
+		err := process(io.Discard, writeTempFile(t, unsorted), options{workers: workers, sortedInput: true, verifySorted: true})
+		if err == nil || !strings.Contains(err.Error(), "isn't sorted") {
+			t.Errorf("unsorted, workers=%d: got error %v, want unsorted input reported", workers, err)
+		}
+	}
+}
+
+func TestProcessSortedInputRanged(t *testing.T) {
+	var sorted, unsorted strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sorted, "Station%02d;%d.%d\n", i/100, i%50-25, i%10)
+		fmt.Fprintf(&unsorted, "Station%02d;%d.%d\n", (i/100+i%2*7)%20, i%50-25, i%10)
+	}
+	want := runProcess(t, sorted.String(), options{workers: 1})
+
+	// run aggregates contents down each of the ranged paths
+	run := func(path string, contents string, opts options) (string, error) {
+		var out bytes.Buffer
+		var err error
+		switch path {
+		case "window":
+			opts.windowSize = 4096
+			err = process(&out, writeTempFile(t, contents), opts)
+		case "checkpoint":
+			opts.windowSize = 4096
+			opts.checkpoint = filepath.Join(t.TempDir(), "run.ckpt")
+			err = process(&out, writeTempFile(t, contents), opts)
+		case "stream":
+			fifo := filepath.Join(t.TempDir(), "fifo")
+			if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+				t.Skipf("cannot make a FIFO: %v", err)
+			}
+			go func() {
+				f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer f.Close()
+				io.WriteString(f, contents)
+			}()
+			opts.windowSize = 4096
+			err = process(&out, fifo, opts)
+		case "encoding":
+			opts.encoding = "utf16le"
+			err = process(&out, writeTempFile(t, string(encodeUTF16(contents, false))), opts)
+		case "tar":
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			if err := tw.WriteHeader(&tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(contents))}); err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(tw, contents)
+			tw.Close()
+			opts.tar = true
+			err = process(&out, writeTempFile(t, archive.String()), opts)
+		case "follow":
+			done := make(chan struct{})
+			close(done)
+			opts.windowSize = 4096
+			err = follow(&out, writeTempFile(t, contents), opts, time.Millisecond, done)
+		}
+		return out.String(), err
+	}
+
+	for _, path := range []string{"window", "checkpoint", "stream", "encoding", "tar", "follow"} {
+		got, err := run(path, sorted.String(), options{workers: 3, sortedInput: true, verifySorted: true})
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if got != want {
+			t.Errorf("%s: got %.200q, want %.200q", path, got, want)
+		}
+
+		if got, err := run(path, unsorted.String(), options{workers: 3, sortedInput: true}); err != nil {
+			t.Errorf("%s, unsorted: %v", path, err)
+		} else if wantUnsorted := runProcess(t, unsorted.String(), options{workers: 1}); got != wantUnsorted {
+			t.Errorf("%s, unsorted: got %.200q, want %.200q", path, got, wantUnsorted)
+		}
+		_, err = run(path, unsorted.String(), options{workers: 3, sortedInput: true, verifySorted: true})
+		if err == nil || !strings.Contains(err.Error(), "isn't sorted") {
+			t.Errorf("%s, unsorted: got error %v, want unsorted input reported", path, err)
+		}
+	}
+
+	// A station out of order across two windows is still caught
+	across := "a;1.0\nb;2.0\n" + strings.Repeat("c;3.0\n", 1000) + "b;4.0\n"
+	if _, err := run("window", across, options{workers: 1, sortedInput: true, verifySorted: true}); err == nil {
+		t.Error("station out of order across windows not reported")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// writeSplit writes res into one file per first byte of the station names
+// in dir, for -split-output, each a complete output in the selected format
+// and order. The stations are sorted once and grouped by first byte, so
+// each file is opened, written and closed in turn.
+func writeSplit(dir string, res *hashtable, opts *options, meta *runMeta) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create -split-output directory: %w", err)
+	}
+
+	populated := populatedItems(res)
+	sortItemsBy(populated, opts.order())
+	sort.SliceStable(populated, func(i, j int) bool {
+		return shardOf(populated[i].key) < shardOf(populated[j].key)
+	})
+
+	for start := 0; start < len(populated); {
+		shard := shardOf(populated[start].key)
+		end := start + 1
+		for end < len(populated) && shardOf(populated[end].key) == shard {
+			end++
+		}
+
+		f, err := createOutputFile(filepath.Join(dir, shardName(shard)))
+		if err != nil {
+			return err
+		}
+		if err := writeResults([]sink{{f, opts.formatter()}}, populated[start:end], opts.writeBuf, opts.flushEvery, meta); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// shardOf returns the first byte of key, or -1 for an empty key.
+func shardOf(key []byte) int {
+	if len(key) == 0 {
+		return -1
+	}
+	return int(key[0])
+}
+
+// shardName names the -split-output file for shard: the byte itself for
+// an uppercase ASCII letter or a digit, its hex code otherwise, and
+// "empty" for the empty station name. Lowercase letters are hex too, as
+// x61 for a, so that no two shards' names differ only in case and collide
+// on a case-insensitive filesystem.
+func shardName(shard int) string {
+	switch c := byte(shard); {
+	case shard < 0:
+		return "empty"
+	case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return string(rune(c))
+	}
+	return fmt.Sprintf("x%02x", shard)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessSplitOutput(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	contents := "Bonn;1.0\nAbha;2.0\nBaku;3.0\nBonn;5.0\n.hidden;4.0\nAccra;-1.0\nabc;6.0\n"
+	if err := process(io.Discard, writeTempFile(t, contents), options{workers: 2, splitDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a's shard can't clash with A's on a case-insensitive filesystem
+	want := map[string]string{
+		"A":   "{Abha=2.0/2.0/2.0, Accra=-1.0/-1.0/-1.0}\n",
+		"B":   "{Baku=3.0/3.0/3.0, Bonn=1.0/3.0/5.0}\n",
+		"x2e": "{.hidden=4.0/4.0/4.0}\n",
+		"x61": "{abc=6.0/6.0/6.0}\n",
+	}
+	seen := make(map[string]int)
+	for shard := -1; shard < 256; shard++ {
+		name := strings.ToLower(shardName(shard))
+		if other, ok := seen[name]; ok {
+			t.Errorf("shards %d and %d are both named %q ignoring case", other, shard, name)
+		}
+		seen[name] = shard
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("got %d files, want %d", len(entries), len(want))
+	}
+	for name, w := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != w {
+			t.Errorf("%s: got %q, want %q", name, got, w)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// defaultStreamBufferSize is how much of a stream is read and processed at
+// a time when -window isn't set.
+const defaultStreamBufferSize = 16 << 20
+
+// processStream aggregates r, an input that can't be mapped such as a FIFO
+// or UTF-16 being decoded, by reading it one buffer at a time. Each buffer is processed up to its
+// last newline and the partial line after it is moved to the front of the
+// buffer to be completed by the next read, so lines must be shorter than
+// the buffer. -window sets the buffer size.
+//
+// Like processWindowed it copies each buffer's stations into an owned
+// table, so it needs the default hashtable accumulator, and since a stream
+// can't be reread it can't checkpoint or resume.
+func processStream(output io.Writer, r io.Reader, opts *options) error {
+	if err := checkStreamable(opts); err != nil {
+		return err
+	}
+
+	totals := newRangeTotals()
+	// Each range's stations are copied into totals, keys and all
+	opts.ownedKeys = true
+	if _, err := totals.stream(r, 0, opts); err != nil {
+		return err
+	}
+	return totals.finish(output, opts)
+}
+
+// checkStreamable reports whether opts can be used on streamed input.
+func checkStreamable(opts *options) error {
+	if opts.accumulator != nil {
+		return fmt.Errorf("custom accumulators can't be used with streamed input")
+	}
+	if opts.checkpoint != "" || opts.resume != "" {
+		return fmt.Errorf("-checkpoint and -resume need a regular file, not a stream")
+	}
+	return nil
+}
+
+// stream reads r to the end into t one buffer at a time, as processStream
+// describes, where r begins offset bytes into the input. It returns the
+// offset just past r.
+func (t *rangeTotals) stream(r io.Reader, offset int64, opts *options) (int64, error) {
+	if opts.normalizeNewlines {
+		// Offsets from here on count the normalized bytes
+		r = newCRLFReader(r)
+	}
+	bufSize := opts.windowSize
+	if bufSize <= 0 {
+		bufSize = defaultStreamBufferSize
+	}
+	buf := make([]byte, bufSize)
+
+	first := offset
+	carry := 0
+	for {
+		n, err := io.ReadFull(r, buf[carry:])
+		atEOF := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !atEOF {
+			return offset, fmt.Errorf("cannot read measurements: %w", err)
+		}
+		data := buf[:carry+n]
+
+		end := len(data)
+		if !atEOF {
+			end = bytes.LastIndexByte(data, opts.eol()) + 1
+			if end == 0 {
+				return offset, fmt.Errorf("line at byte %d is longer than the %d byte buffer", offset, bufSize)
+			}
+		}
+
+		start := 0
+		if offset == first {
+			start = bomLen(data)
+		}
+		opts.logf("stream: %d bytes from byte %d", end-start, offset+int64(start))
+		if err := t.add(data, start, end, offset, opts); err != nil {
+			return offset, err
+		}
+		if atEOF {
+			t.endInput(opts)
+			return offset + int64(end), nil
+		}
+
+		carry = copy(buf, data[end:])
+		offset += int64(end)
+	}
+}
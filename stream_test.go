@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestProcessFIFO(t *testing.T) {
+	var buf strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&buf, "Station%02d;%d.%d\n", i%37, i%90, i%10)
+	}
+	buf.WriteString("Tail;-1.5")
+	contents := buf.String()
+
+	for _, opts := range []options{
+		{workers: 3},
+		// Small enough that lines straddle reads
+		{workers: 2, windowSize: 1000, offsets: true},
+		{workers: 2, countOnly: true},
+		{workers: 2, check: true},
+	} {
+		want := runProcess(t, contents, opts)
+
+		path := filepath.Join(t.TempDir(), "fifo")
+		if err := syscall.Mkfifo(path, 0o600); err != nil {
+			t.Skipf("cannot make a FIFO: %v", err)
+		}
+		go func() {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			io.WriteString(f, contents)
+		}()
+
+		var out bytes.Buffer
+		if err := process(&out, path, opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%+v: got %.200q, want %.200q", opts, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	// maxReportedRejects is how many malformed lines -strict prints
+	maxReportedRejects = 10
+
+	// rejectSnippetLen is how much of a malformed line is kept for the report
+	rejectSnippetLen = 64
+)
+
+// rejections records the malformed lines a worker rejected in strict mode.
+// Only the first few are kept in full; the rest are just counted.
+type rejections struct {
+	count int
+	first []rejectedLine
+}
+
+// rejectedLine is a malformed line's byte offset in the file and a copy of
+// its leading bytes.
+type rejectedLine struct {
+	offset  int
+	snippet []byte
+}
+
+// add records the line data[lineStart:lineEnd] as rejected.
+func (r *rejections) add(data []byte, lineStart, lineEnd int) {
+	r.count++
+	if len(r.first) == maxReportedRejects {
+		return
+	}
+	end := lineEnd
+	if end-lineStart > rejectSnippetLen {
+		end = lineStart + rejectSnippetLen
+	}
+	r.first = append(r.first, rejectedLine{
+		offset:  lineStart,
+		snippet: append([]byte(nil), data[lineStart:end]...),
+	})
+}
+
+// merge appends a later range's rejections to r.
+func (r *rejections) merge(other rejections) {
+	r.count += other.count
+	for _, line := range other.first {
+		if len(r.first) == maxReportedRejects {
+			break
+		}
+		r.first = append(r.first, line)
+	}
+}
+
+// shift moves the recorded offsets by base, for lines read from a window
+// that starts base bytes into the file.
+func (r *rejections) shift(base int) {
+	for i := range r.first {
+		r.first[i].offset += base
+	}
+}
+
+// reportRejected writes the first malformed lines across all workers to w
+// and returns an error if there were any. Workers are in file order, so
+// concatenating their records keeps the report in offset order.
+func reportRejected(w io.Writer, results []*chunkResult) error {
+	total := 0
+	reported := 0
+	for _, r := range results {
+		total += r.rejected.count
+		for _, line := range r.rejected.first {
+			if reported == maxReportedRejects {
+				break
+			}
+			if w != nil {
+				fmt.Fprintf(w, "malformed line at byte %d: %q\n", line.offset, line.snippet)
+			}
+			reported++
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d malformed lines", total)
+}
+
+// parseTemp parses a temperature in the canonical 1BRC format, an optional
+// sign, one or two integer digits and exactly one decimal digit, reporting
+// whether b was well formed.
+func parseTemp(b []byte) (int32, bool) {
+	idx := 0
+	negative := len(b) > 0 && b[0] == '-'
+	if negative {
+		idx++
+	}
+
+	digits := len(b) - idx
+	if digits != 3 && digits != 4 {
+		return 0, false
+	}
+
+	var val int32
+	for ; idx < len(b); idx++ {
+		c := b[idx]
+		if idx == len(b)-2 {
+			if c != '.' {
+				return 0, false
+			}
+			continue
+		}
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		val = val*10 + int32(c-'0')
+	}
+
+	if negative {
+		return -val, true
+	}
+	return val, true
+}
+
+// parseTempGeneral parses a temperature with an optional sign, any number
+// of integer digits and an optional fraction of any length, rounded half
+// away from zero to tenths and saturated to the int32 range. It reports
+// whether b was well formed; a malformed b still yields the value of its
+// leading well-formed part, as the fast parser would.
+func parseTempGeneral(b []byte) (int32, bool) {
+	idx := 0
+	negative := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		negative = b[0] == '-'
+		idx++
+	}
+
+	var val int64
+	digits := 0
+	for ; idx < len(b) && b[idx] >= '0' && b[idx] <= '9'; idx++ {
+		if val < math.MaxInt32 {
+			val = val*10 + int64(b[idx]-'0')
+		}
+		digits++
+	}
+	val *= 10
+
+	ok := digits > 0
+	if idx < len(b) && b[idx] == '.' {
+		idx++
+		frac := 0
+		for ; idx < len(b) && b[idx] >= '0' && b[idx] <= '9'; idx++ {
+			switch frac {
+			case 0:
+				val += int64(b[idx] - '0')
+			case 1:
+				if b[idx] >= '5' {
+					val++
+				}
+			}
+			frac++
+		}
+		ok = ok || frac > 0
+	}
+	ok = ok && idx == len(b)
+
+	if val > math.MaxInt32 {
+		val = math.MaxInt32
+	}
+	if negative {
+		val = -val
+	}
+	return int32(val), ok
+}
+
+// parseTempSci is parseTempGeneral falling back to strconv.ParseFloat for
+// what it can't parse, for -sci-notation, so exponents such as 1.2e1 are
+// accepted. The float is rounded half away from zero to tenths like the
+// general parser does, and saturated to the int32 range; NaN and
+// infinities are malformed.
+func parseTempSci(b []byte) (int32, bool) {
+	if v, ok := parseTempGeneral(b); ok {
+		return v, true
+	}
+	// Out of range values come back as infinities with ErrRange and
+	// saturate, but NaN or Inf spelled out aren't temperatures
+	f, err := strconv.ParseFloat(string(b), 64)
+	overflow := errors.Is(err, strconv.ErrRange)
+	if err != nil && !overflow || math.IsNaN(f) || math.IsInf(f, 0) && !overflow {
+		v, _ := parseTempGeneral(b)
+		return v, false
+	}
+	tenths := math.Round(f * 10)
+	switch {
+	case tenths > math.MaxInt32:
+		return math.MaxInt32, true
+	case tenths < -math.MaxInt32:
+		return -math.MaxInt32, true
+	}
+	return int32(tenths), true
+}
+
+// ParseTemperature parses a temperature as -parse-mode=strict does: an
+// optional sign, any number of integer digits and an optional fraction,
+// rounded to tenths and saturated to the int32 range. The result is in
+// tenths of a degree. Unlike the hot-path parsers it returns an error for
+// malformed input rather than a partial value.
+func ParseTemperature(b []byte) (int32, error) {
+	v, ok := parseTempGeneral(b)
+	if !ok {
+		return 0, fmt.Errorf("malformed temperature %q", b)
+	}
+	return v, nil
+}
+
+// parseTempTenths parses a temperature given as a signed whole number of
+// tenths, for -tenths, saturated to the int32 range. It reports whether b
+// was an optional sign followed only by digits, yielding the value of its
+// leading digits otherwise as tenthsToFixedPointInt would.
+func parseTempTenths(b []byte) (int32, bool) {
+	idx := 0
+	negative := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		negative = b[0] == '-'
+		idx++
+	}
+
+	first := idx
+	var val int64
+	for ; idx < len(b) && b[idx] >= '0' && b[idx] <= '9'; idx++ {
+		if val < math.MaxInt32 {
+			val = val*10 + int64(b[idx]-'0')
+		}
+	}
+	ok := idx > first && idx == len(b)
+
+	if val > math.MaxInt32 {
+		val = math.MaxInt32
+	}
+	if negative {
+		val = -val
+	}
+	return int32(val), ok
+}
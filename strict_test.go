@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProcessStrictReportsMalformedLines(t *testing.T) {
+	contents := "Abha;12.3\nno delimiter\nBeirut;12.x\n;1.0\nAbha;-1.0\n\nCairo;123.4"
+	var diag bytes.Buffer
+	for _, workers := range []int{1, 3} {
+		diag.Reset()
+		var out bytes.Buffer
+		err := process(&out, writeTempFile(t, contents), options{workers: workers, strict: true, diag: &diag})
+		if err == nil || err.Error() != "5 malformed lines" {
+			t.Fatalf("workers=%d: got error %v", workers, err)
+		}
+		want := "" +
+			"malformed line at byte 10: \"no delimiter\"\n" +
+			"malformed line at byte 23: \"Beirut;12.x\"\n" +
+			"malformed line at byte 35: \";1.0\"\n" +
+			"malformed line at byte 50: \"\"\n" +
+			"malformed line at byte 51: \"Cairo;123.4\"\n"
+		if diag.String() != want {
+			t.Errorf("workers=%d: got report\n%s\nwant\n%s", workers, diag.String(), want)
+		}
+	}
+}
+
+func TestProcessStrictAcceptsCleanInput(t *testing.T) {
+	got := runProcess(t, "Abha;12.3\nBeirut;-4.5\n", options{workers: 2, strict: true})
+	want := "{Abha=12.3/12.3/12.3, Beirut=-4.5/-4.5/-4.5}\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
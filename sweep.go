@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+const (
+	// sweepSampleBytes caps the prefix of the file -sweep times
+	sweepSampleBytes = 256 << 20
+
+	// sweepRounds is how many times each worker count is timed, keeping
+	// the fastest to damp noise
+	sweepRounds = 3
+
+	// sweepFlatGain is the least speedup over the best throughput so far
+	// that counts as still scaling
+	sweepFlatGain = 1.1
+)
+
+// sweep implements -sweep: it reads a newline-aligned prefix of fileName,
+// up to sweepSampleBytes, into memory and times the aggregation of it with
+// each of sweepWorkerCounts(NumCPU) workers, printing the throughput of
+// each and the count past which more workers stop helping. Reading the
+// sample first takes the disk out of the measurement, so what it finds is
+// where the job becomes memory bandwidth bound.
+func sweep(w io.Writer, fileName string, opts options) error {
+	file, err := openMeasurements(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size := int64(sweepSampleBytes)
+	if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() && stat.Size() < size {
+		size = stat.Size()
+	}
+	data := make([]byte, size)
+	n, err := io.ReadFull(file, data)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("cannot read measurements: %w", err)
+	}
+	data = data[:n]
+	if n == sweepSampleBytes {
+		data = data[:bytes.LastIndexByte(data, opts.eol())+1]
+	}
+	start := bomLen(data)
+	if len(data) == start {
+		return fmt.Errorf("-sweep: no complete line in the first %d bytes of %s", sweepSampleBytes, fileName)
+	}
+	sample := float64(len(data)-start) / (1 << 20)
+	fmt.Fprintf(w, "sweep: %.1f MiB sample, best of %d rounds\n", sample, sweepRounds)
+
+	var knee int
+	var best float64
+	for _, workers := range sweepWorkerCounts(runtime.NumCPU()) {
+		passOpts := opts
+		passOpts.workers, passOpts.autoWorkers = workers, false
+		var fastest time.Duration
+		for round := 0; round < sweepRounds; round++ {
+			began := time.Now()
+			processRange(data, start, len(data), &passOpts)
+			if elapsed := time.Since(began); round == 0 || elapsed < fastest {
+				fastest = elapsed
+			}
+		}
+
+		mbps := sample / fastest.Seconds()
+		fmt.Fprintf(w, "sweep: %3d workers %8.1f MiB/s\n", workers, mbps)
+		if mbps > best*sweepFlatGain {
+			knee = workers
+		}
+		if mbps > best {
+			best = mbps
+		}
+	}
+	fmt.Fprintf(w, "sweep: scaling flattens at %d workers\n", knee)
+	return nil
+}
+
+// sweepWorkerCounts returns the worker counts -sweep times: the powers of
+// two below numCPU, then numCPU.
+func sweepWorkerCounts(numCPU int) []int {
+	var counts []int
+	for n := 1; n < numCPU; n *= 2 {
+		counts = append(counts, n)
+	}
+	return append(counts, numCPU)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSweepWorkerCounts(t *testing.T) {
+	tests := map[int][]int{
+		1:  {1},
+		2:  {1, 2},
+		6:  {1, 2, 4, 6},
+		16: {1, 2, 4, 8, 16},
+	}
+	for numCPU, want := range tests {
+		if got := sweepWorkerCounts(numCPU); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("sweepWorkerCounts(%d) = %v, want %v", numCPU, got, want)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	path := writeTempFile(t, "\ufeffa;1.0\nb;2.0\n")
+	var out bytes.Buffer
+	if err := sweep(&out, path, options{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if want := len(sweepWorkerCounts(runtime.NumCPU())) + 2; len(lines) != want {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), want, out.String())
+	}
+	if !strings.HasPrefix(lines[1], "sweep:   1 workers") || !strings.HasPrefix(lines[len(lines)-1], "sweep: scaling flattens at ") {
+		t.Errorf("got %q", out.String())
+	}
+}
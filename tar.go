@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// processTar aggregates the members of the tar archive r together, for
+// -tar, streaming each through the same buffers as processStream into one
+// set of totals. Directories and other non-regular entries are skipped, as
+// are members whose names don't end in opts.tarExt, unless it's empty.
+//
+// The members are read as one input in archive order, so offsets in
+// errors and -offsets count from the first member, but each may start
+// with its own byte order mark.
+func processTar(output io.Writer, r io.Reader, opts *options) error {
+	if err := checkStreamable(opts); err != nil {
+		return err
+	}
+
+	totals := newRangeTotals()
+	// Each range's stations are copied into totals, keys and all
+	opts.ownedKeys = true
+
+	archive := tar.NewReader(r)
+	offset := int64(0)
+	members := 0
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read tar archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, opts.tarExt) {
+			opts.logf("tar: skipping %s", hdr.Name)
+			continue
+		}
+
+		opts.logf("tar: %s, %d bytes from byte %d", hdr.Name, hdr.Size, offset)
+		var member io.Reader = archive
+		if opts.encoding != "" {
+			member = newUTF16Reader(archive, opts.encoding == "utf16be")
+		}
+		if offset, err = totals.stream(member, offset, opts); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		members++
+	}
+	opts.logf("tar: read %d members", members)
+	return totals.finish(output, opts)
+}
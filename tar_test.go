@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func TestProcessTar(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	members := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "2024/", Typeflag: tar.TypeDir, Mode: 0o755}, ""},
+		{tar.Header{Name: "2024/a.txt", Typeflag: tar.TypeReg, Mode: 0o644}, "b;1.0\na;-2.5"}, // no final newline
+		{tar.Header{Name: "2024/README", Typeflag: tar.TypeReg, Mode: 0o644}, "not;measurements\n"},
+		{tar.Header{Name: "2024/b.txt", Typeflag: tar.TypeReg, Mode: 0o644}, "\ufeffb;3.0\na;0.0\n"},
+	}
+	for _, m := range members {
+		m.hdr.Size = int64(len(m.body))
+		if err := tw.WriteHeader(&m.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(m.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTempFile(t, archive.String())
+
+	tests := []struct {
+		opts options
+		want string
+	}{
+		{options{workers: 2, tar: true, tarExt: ".txt"}, "{a=-2.5/-1.2/0.0, b=1.0/2.0/3.0}\n"},
+		{options{workers: 2, tar: true, tarExt: ".txt", windowSize: 10}, "{a=-2.5/-1.2/0.0, b=1.0/2.0/3.0}\n"},
+		{options{workers: 2, tar: true, tarExt: ".txt", countOnly: true}, "4\n"},
+		{options{workers: 2, tar: true, countOnly: true}, "5\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := process(&out, path, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("ext %q, window %d, count-only %v: got %q, want %q", tt.opts.tarExt, tt.opts.windowSize, tt.opts.countOnly, out.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleChunk is how many bytes a throttled worker processes between
+// draws on the shared budget.
+const throttleChunk = 1 << 20
+
+// throttle is a token bucket shared by all workers that limits how fast
+// they advance through the input, and so how fast pages are faulted in
+// from disk. It is approximate: each draw reserves time for the bytes up
+// front, and nothing is saved up while workers are idle.
+type throttle struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+
+	// next is when the bytes reserved so far will have been paid for
+	next time.Time
+}
+
+func newThrottle(bytesPerSec float64) *throttle {
+	return &throttle{bytesPerSec: bytesPerSec}
+}
+
+// wait blocks until n more bytes fit within the rate.
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / t.bytesPerSec * float64(time.Second)))
+	delay := t.next.Sub(now)
+	t.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// processThrottled processes data[start:end] in line-aligned pieces of
+// about throttleChunk bytes, drawing each piece from the throttle first.
+func processThrottled(data []byte, start, end int, opts *options, acc Accumulator) *chunkResult {
+	pieces := (end - start) / throttleChunk
+	res := &chunkResult{acc: acc}
+	for _, blk := range splitBlocks(data, start, end, pieces, opts.eol()) {
+		opts.throttle.wait(blk.end - blk.start)
+		r := processBlock(data, blk.start, blk.end, opts, acc)
+		res.absorb(r)
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProcessThrottled(t *testing.T) {
+	var buf bytes.Buffer
+	for buf.Len() < 200_000 {
+		buf.WriteString("Abha;12.3\nBeirut;-4.5\n")
+	}
+	contents := buf.String()
+	want := runProcess(t, contents, options{workers: 2})
+
+	start := time.Now()
+	got := runProcess(t, contents, options{workers: 2, throttle: newThrottle(1 << 20)})
+	elapsed := time.Since(start)
+
+	if got != want {
+		t.Errorf("throttled output differs: got %q, want %q", got, want)
+	}
+	// 200KB at 1MiB/s should take around 190ms
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("throttled run took %v, expected at least 150ms", elapsed)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"time"
+)
+
+// noTime marks a row, or an extreme, without a timestamp
+const noTime = math.MinInt64
+
+// extremeTimes is when a station's min and max were read, in Unix
+// nanoseconds or noTime, for -with-time.
+type extremeTimes struct {
+	min, max int64
+}
+
+// splitTime splits the optional third field of a row, its timestamp, off
+// b, the rest of the row after the station, returning the temperature and
+// the time it parses to in Unix nanoseconds. A row without a third field,
+// or with one that doesn't parse, has noTime. delim is the field separator,
+// or nil for ';'.
+func splitTime(b, delim []byte, epoch bool) ([]byte, int64) {
+	var i int
+	if delim == nil {
+		i = bytes.IndexByte(b, ';')
+		delim = []byte{';'}
+	} else {
+		i = bytes.Index(b, delim)
+	}
+	if i < 0 {
+		return b, noTime
+	}
+	return b[:i], parseTimestamp(b[i+len(delim):], epoch)
+}
+
+// parseTimestamp parses b as whole seconds since the Unix epoch or, if not
+// epoch, as RFC 3339, returning noTime for anything else or a time that
+// Unix nanoseconds can't hold, outside the years 1678 to 2262.
+func parseTimestamp(b []byte, epoch bool) int64 {
+	if !epoch {
+		t, err := time.Parse(time.RFC3339Nano, string(b))
+		if err != nil || t.Year() < 1678 || t.Year() > 2262 {
+			return noTime
+		}
+		return t.UnixNano()
+	}
+
+	negative := len(b) > 0 && b[0] == '-'
+	digits := b
+	if negative {
+		digits = b[1:]
+	}
+	if len(digits) == 0 {
+		return noTime
+	}
+	const limit = math.MaxInt64 / int64(time.Second)
+	var secs int64
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return noTime
+		}
+		if secs = secs*10 + int64(c-'0'); secs > limit {
+			return noTime
+		}
+	}
+	if negative {
+		secs = -secs
+	}
+	return secs * int64(time.Second)
+}
+
+// recordTimes notes at as the time of s's min if isMin, or max if isMax.
+func (s *stats) recordTimes(at int64, isMin, isMax bool) {
+	if s.times == nil {
+		// A seeded station's first row
+		s.times = &extremeTimes{min: noTime, max: noTime}
+	}
+	if isMin {
+		s.times.min = at
+	}
+	if isMax {
+		s.times.max = at
+	}
+}
+
+// appendTime appends the Unix nanoseconds t in RFC 3339 in UTC, or NA for
+// noTime.
+func appendTime(dst []byte, t int64) []byte {
+	if t == noTime {
+		return append(dst, "NA"...)
+	}
+	return time.Unix(0, t).UTC().AppendFormat(dst, time.RFC3339Nano)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessWithTime(t *testing.T) {
+	contents := "a;1.0;2024-01-01T00:00:00Z\n" +
+		"b;2.0\n" +
+		"a;3.0;2024-01-02T10:00:00+02:00\n" +
+		"a;-1.0;2024-01-03T00:00:00.5Z\n" +
+		"a;3.0;2024-01-04T00:00:00Z\n" +
+		"b;5.0;not a time\n"
+	want := "{a=-1.0/1.5/3.0 @2024-01-03T00:00:00.5Z/2024-01-02T08:00:00Z, b=2.0/3.5/5.0 @NA/NA}\n"
+	for _, opts := range []options{
+		{workers: 1},
+		{workers: 3},
+		{workers: 2, windowSize: 64},
+	} {
+		opts.withTime = true
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: got %q, want %q", opts, got, want)
+		}
+	}
+
+	// -check accepts what the run does, timestamp or not
+	if got, want := runProcess(t, contents, options{workers: 2, withTime: true, check: true}), "6 valid lines, 0 malformed lines\n"; got != want {
+		t.Errorf("check: got %q, want %q", got, want)
+	}
+	if got, want := runProcess(t, "a;12.3;1700000000\n", options{workers: 1, withTime: true, epochTime: true, check: true}), "1 valid lines, 0 malformed lines\n"; got != want {
+		t.Errorf("check epoch: got %q, want %q", got, want)
+	}
+
+	got := runProcess(t, "a;1.0;1700000000\na;3.0;1700000100\n", options{workers: 2, withTime: true, epochTime: true, format: "json"})
+	want = `{"a":{"min":1.0,"mean":2.0,"max":3.0,"count":2,"min_time":"2023-11-14T22:13:20Z","max_time":"2023-11-14T22:15:00Z"}}` + "\n"
+	if got != want {
+		t.Errorf("epoch: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// stationTrace writes each worker's local stats for one station before
+// the merge, for -trace-station, so a wrong total can be pinned to the
+// block that contributed it. It is shared by all workers.
+type stationTrace struct {
+	mu   sync.Mutex
+	w    io.Writer
+	name []byte
+	hash fnvHash
+}
+
+func newStationTrace(w io.Writer, name string) *stationTrace {
+	key := []byte(name)
+	return &stationTrace{w: w, name: key, hash: hashBytes(key, 0, len(key))}
+}
+
+// report writes worker's min, max, sum and row count for the station, or
+// that it had none, on one line. blk is the worker's block, or nil for a
+// worker that drew batches under -dispatch-batch. Accumulators other than
+// the default hashtable can't be looked up, so they aren't reported.
+func (t *stationTrace) report(worker int, blk *block, res *chunkResult) {
+	if t == nil || t.w == nil || res == nil {
+		return
+	}
+	ht, ok := res.acc.(*hashtable)
+	if !ok {
+		return
+	}
+	s := ht.get(t.hash, t.name)
+
+	line := fmt.Appendf(nil, "trace %q: worker %d", t.name, worker)
+	if blk != nil {
+		line = fmt.Appendf(line, ", bytes %d-%d", blk.start, blk.end)
+	}
+	if s == nil || s.count == 0 {
+		line = append(line, ": no rows"...)
+	} else {
+		line = append(line, ": min="...)
+		line = appendTenths(line, int64(s.min))
+		line = append(line, " max="...)
+		line = appendTenths(line, int64(s.max))
+		line = append(line, " sum="...)
+		line = appendSumTenths(line, s)
+		line = fmt.Appendf(line, " count=%d", s.count)
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(line)
+}
+
+// appendSumTenths appends s's sum in degrees, exactly even once -wide-sum
+// has carried it past 64 bits.
+func appendSumTenths(dst []byte, s *stats) []byte {
+	if s.sumHi == 0 {
+		return appendTenths(dst, s.sum)
+	}
+	sum := new(big.Int).Lsh(big.NewInt(s.sumHi), 64)
+	sum.Add(sum, big.NewInt(s.sum))
+	if sum.Sign() < 0 {
+		dst = append(dst, '-')
+		sum.Neg(sum)
+	}
+	digits := sum.String()
+	return append(append(append(dst, digits[:len(digits)-1]...), '.'), digits[len(digits)-1])
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestProcessTraceStation(t *testing.T) {
+	contents := "a;1.0\nb;2.0\na;3.0\nc;4.0\nc;-5.0\nc;6.0\n"
+	tests := []struct {
+		opts options
+		name string
+		want []string
+	}{
+		{options{workers: 2}, "c", []string{
+			`trace "c": worker 0, bytes 0-24: min=4.0 max=4.0 sum=4.0 count=1`,
+			`trace "c": worker 1, bytes 24-37: min=-5.0 max=6.0 sum=1.0 count=2`,
+		}},
+		{options{workers: 2}, "a", []string{
+			`trace "a": worker 0, bytes 0-24: min=1.0 max=3.0 sum=4.0 count=2`,
+			`trace "a": worker 1, bytes 24-37: no rows`,
+		}},
+		{options{workers: 1, dispatchBatch: 1 << 20}, "c", []string{
+			`trace "c": worker 0: min=-5.0 max=6.0 sum=5.0 count=3`,
+		}},
+	}
+	for _, tt := range tests {
+		var diag bytes.Buffer
+		tt.opts.trace = newStationTrace(&diag, tt.name)
+		runProcess(t, contents, tt.opts)
+		got := strings.Split(strings.TrimSuffix(diag.String(), "\n"), "\n")
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s, %d workers: got %q, want %q", tt.name, tt.opts.workers, got, tt.want)
+		}
+	}
+
+	wide := &stats{sum: math.MinInt64, sumHi: -1}
+	if got := string(appendSumTenths(nil, wide)); got != "-2767011611056432742.4" {
+		t.Errorf("wide sum: got %s", got)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// maxReportedInvalidUTF8 is how many invalid station names -check-utf8
+// prints
+const maxReportedInvalidUTF8 = 10
+
+// reportInvalidUTF8 implements -check-utf8. The merged table holds each
+// station once, so every name is validated once however many rows it has.
+// The first invalid names are written to w; under strict the run fails if
+// there were any.
+func reportInvalidUTF8(w io.Writer, ht *hashtable, strict bool) error {
+	var invalid []item
+	for _, item := range ht.items {
+		if item.value != nil && !utf8.Valid(item.key) {
+			invalid = append(invalid, item)
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	sortItems(invalid)
+	if w != nil {
+		for i, item := range invalid {
+			if i == maxReportedInvalidUTF8 {
+				fmt.Fprintf(w, "... and %d more\n", len(invalid)-i)
+				break
+			}
+			fmt.Fprintf(w, "invalid UTF-8 in station name %q: %d rows\n", item.key, item.value.count)
+		}
+	}
+	if strict {
+		return fmt.Errorf("%d station names are not valid UTF-8", len(invalid))
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestProcessCheckUTF8(t *testing.T) {
+	contents := "ok;1.0\nbad\xff;2.0\nbad\xff;3.0\nCaf\xc3\xa9;4.0\n"
+	var out, diag bytes.Buffer
+	if err := process(&out, writeTempFile(t, contents), options{workers: 2, checkUTF8: true, diag: &diag}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{Café=4.0/4.0/4.0, bad\xff=2.0/2.5/3.0, ok=1.0/1.0/1.0}\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if want := "invalid UTF-8 in station name \"bad\\xff\": 2 rows\n"; diag.String() != want {
+		t.Errorf("diag: got %q, want %q", diag.String(), want)
+	}
+
+	err := process(io.Discard, writeTempFile(t, contents), options{workers: 2, checkUTF8: true, strict: true})
+	if err == nil || err.Error() != "1 station names are not valid UTF-8" {
+		t.Errorf("strict: got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// defaultWindowSize is how much of the file is mapped at a time when it
+// can't be mapped in one go.
+const defaultWindowSize = 256 << 20
+
+// maxMappingSize returns the largest file mapped in a single shot. A 32-bit
+// address space can't hold a multi-GB mapping, so there anything over 1GB
+// is processed in windows.
+func maxMappingSize() int64 {
+	if strconv.IntSize == 32 {
+		return 1 << 30
+	}
+	return math.MaxInt64
+}
+
+// processWindowed aggregates file by mapping it one window at a time. Each
+// window is processed up to its last newline and unmapped before the next
+// is mapped from the page containing the first unprocessed byte, so a line
+// straddling two windows is handled whole by the second. Lines must be
+// shorter than the window.
+//
+// Keys alias the window they were read from, so each window's stations are
+// copied into an owned table before it's unmapped. That requires the
+// default hashtable accumulator.
+//
+// Under -checkpoint the owned table is saved after a window once the
+// interval has passed, and -resume starts from such a table and its offset.
+// Lines rejected by -strict or counted by -stats before the checkpoint
+// aren't saved, so a resumed run only reports those of the rest.
+func processWindowed(output io.Writer, file *os.File, size int64, opts *options) error {
+	if opts.accumulator != nil {
+		return fmt.Errorf("custom accumulators can't be used with windowed processing")
+	}
+
+	pageSize := int64(os.Getpagesize())
+	windowSize := opts.windowSize
+	if windowSize <= 0 {
+		windowSize = defaultWindowSize
+	}
+	// Windows must start on a page boundary, so round up to whole pages
+	windowSize = (windowSize + pageSize - 1) / pageSize * pageSize
+
+	totals := newRangeTotals()
+	// Each range's stations are copied into totals, keys and all
+	opts.ownedKeys = true
+	merged := totals.merged
+
+	offset := int64(0)
+	skip := 0
+	if opts.resume != "" {
+		next, err := loadCheckpoint(opts.resume, size, merged)
+		if err != nil {
+			return err
+		}
+		opts.logf("resuming from byte %d with %d stations from %s", next, merged.size, opts.resume)
+		offset = next / pageSize * pageSize
+		skip = int(next - offset)
+	}
+	lastCheckpoint := time.Now()
+
+	for offset+int64(skip) < size {
+		// Map a full window beyond the already processed bytes at its start
+		length := int64(skip) + windowSize
+		if offset+length > size {
+			length = size - offset
+		}
+		data, err := mapFile(file, offset, int(length), opts)
+		if err != nil {
+			return err
+		}
+
+		if offset == 0 && skip == 0 {
+			skip = bomLen(data)
+		}
+		end := len(data)
+		if offset+length < size {
+			end = bytes.LastIndexByte(data[skip:], opts.eol()) + skip + 1
+			if end == skip {
+				syscall.Munmap(data)
+				return fmt.Errorf("line at byte %d is longer than the %d byte window", offset+int64(skip), windowSize)
+			}
+		}
+
+		opts.logf("window: %d bytes from byte %d", end-skip, offset+int64(skip))
+		addErr := totals.add(data, skip, end, offset, opts)
+
+		if err := syscall.Munmap(data); err != nil {
+			return err
+		}
+		if addErr != nil {
+			return addErr
+		}
+
+		next := offset + int64(end)
+		if opts.checkpoint != "" && time.Since(lastCheckpoint) >= opts.checkpointInterval {
+			if err := writeCheckpoint(opts.checkpoint, size, next, merged); err != nil {
+				return fmt.Errorf("cannot write checkpoint: %w", err)
+			}
+			opts.logf("checkpoint: %d stations up to byte %d", merged.size, next)
+			lastCheckpoint = time.Now()
+		}
+		offset = next / pageSize * pageSize
+		skip = int(next - offset)
+	}
+
+	return totals.finish(output, opts)
+}
+
+// rangeTotals accumulates the results of processing a file one range at a
+// time, for the windowed and streaming paths. Each range's stations are
+// copied into merged, so the range's memory can be reused once added.
+type rangeTotals struct {
+	merged   *hashtable
+	rejected []*chunkResult
+	skipped  skipCounts
+	clamped  int
+	lengths  lineStats
+	rows     int
+	checked  checkResult
+	lastByte byte
+	nonEmpty bool
+
+	// lastRun is a copy of the last station added under -verify-sorted,
+	// which the next range's first has to sort after
+	lastRun []byte
+}
+
+func newRangeTotals() *rangeTotals {
+	return &rangeTotals{merged: NewHashTable(1 << 18), checked: checkResult{firstMalformed: -1}}
+}
+
+// add processes data[start:end], which ends after a newline or at the end
+// of the input, and where data begins offset bytes into the input. The
+// errors are -fail-fast's, -max-stations' and -verify-sorted's.
+func (t *rangeTotals) add(data []byte, start, end int, offset int64, opts *options) error {
+	if end == start {
+		return nil
+	}
+	t.lastByte = data[end-1]
+	t.nonEmpty = true
+
+	switch {
+	case opts.countOnly:
+		t.rows += countByte(data, start, end, opts.eol())
+	case opts.check:
+		t.checked.add(checkRange(data, start, end, opts), int(offset))
+	default:
+		if opts.failFast {
+			opts.failure = newFirstFailure()
+		}
+		results := processRange(data, start, end, opts)
+		if err := opts.failure.err(offset); err != nil {
+			return err
+		}
+		if err := opts.maxStations.err(); err != nil {
+			return err
+		}
+		for _, r := range results {
+			ht, err := t.table(r.acc)
+			if err != nil {
+				return err
+			}
+			if opts.offsets {
+				ht.shiftOffsets(offset)
+			}
+			t.merged.mergeOwned(ht)
+			t.skipped.merge(r.skipped)
+			t.clamped += r.clamped
+			t.lengths.merge(r.lengths)
+			if r.rejected.count > 0 {
+				r.rejected.shift(int(offset))
+				t.rejected = append(t.rejected, &chunkResult{rejected: r.rejected})
+			}
+		}
+		// Check the merged table after each range, so a stream fails as
+		// early as a mapped file
+		if err := opts.maxStations.check(t.merged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// table returns acc, the accumulator of one worker's part of a range, as
+// the hashtable to merge. -sorted-input's runs are turned into one, after
+// checking under -verify-sorted that they follow on from the last range's.
+func (t *rangeTotals) table(acc Accumulator) (*hashtable, error) {
+	runs, ok := acc.(*sortedRuns)
+	if !ok {
+		return acc.(*hashtable), nil
+	}
+	if err := runs.follows(t.lastRun); err != nil {
+		return nil, err
+	}
+	if n := len(runs.runs); n > 0 && runs.verify {
+		t.lastRun = append(t.lastRun[:0], runs.runs[n-1].key...)
+	}
+	return runs.table(), nil
+}
+
+// finish reports the totals as process would for the whole input.
+func (t *rangeTotals) finish(output io.Writer, opts *options) error {
+	switch {
+	case opts.countOnly:
+		t.endInput(opts)
+		opts.manifest.countedRows(t.rows, opts.workers)
+		_, err := fmt.Fprintln(output, t.rows)
+		return err
+	case opts.check:
+		return reportCheck(output, t.checked)
+	}
+
+	if opts.stats {
+		reportSkips(opts.diag, t.skipped)
+		reportClamped(opts.diag, t.clamped, opts.clamp)
+	}
+	if opts.lineStats {
+		reportLineStats(opts.diag, t.lengths)
+	}
+	if opts.strict {
+		if err := reportRejected(opts.diag, t.rejected); err != nil {
+			return err
+		}
+	}
+	return writeOutput(output, t.merged, opts)
+}
+
+// endInput ends one input of several added in turn, such as the members of
+// a -tar archive, counting a last row without a trailing newline for
+// -count-only.
+func (t *rangeTotals) endInput(opts *options) {
+	if opts.countOnly && t.nonEmpty && t.lastByte != opts.eol() {
+		t.rows++
+	}
+	t.nonEmpty = false
+}
+
+// mergeOwned folds other into ht like Merge, but copies the key of any
+// station new to ht so ht stays valid once other's input is unmapped.
+func (ht *hashtable) mergeOwned(other *hashtable) {
+	for _, item := range other.items {
+		if item.value == nil {
+			continue
+		}
+
+		s := ht.get(item.hash, item.key)
+		if s == nil {
+			v := *item.value
+			ht.add(item.hash, bytes.Clone(item.key), &v)
+		} else {
+			s.merge(item.value)
+		}
+	}
+}
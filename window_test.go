@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestProcessWindowedMatchesSingleMapping(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		if i%5000 == 4999 {
+			buf.WriteString("malformed line\n")
+			continue
+		}
+		fmt.Fprintf(&buf, "Station%03d;%.1f\n", rng.Intn(413), rng.Float64()*199.8-99.9)
+	}
+	// End without a newline so the last window holds a partial line
+	buf.WriteString("Station000;1.0")
+	contents := buf.String()
+
+	for _, opts := range []options{
+		{workers: 3},
+		{workers: 3, countOnly: true},
+	} {
+		want := runProcess(t, contents, opts)
+		opts.windowSize = 4096
+		if got := runProcess(t, contents, opts); got != want {
+			t.Errorf("%+v: windowed output differs:\ngot  %.200q\nwant %.200q", opts, got, want)
+		}
+	}
+
+	for _, opts := range []options{
+		{workers: 3, check: true},
+		{workers: 3, strict: true},
+	} {
+		path := writeTempFile(t, contents)
+		var want, wantDiag bytes.Buffer
+		opts.diag = &wantDiag
+		wantErr := process(&want, path, opts)
+
+		var got, gotDiag bytes.Buffer
+		opts.diag = &gotDiag
+		opts.windowSize = 4096
+		gotErr := process(&got, path, opts)
+
+		if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) || got.String() != want.String() || gotDiag.String() != wantDiag.String() {
+			t.Errorf("check=%v strict=%v: windowed run differs:\ngot  %v %q %q\nwant %v %q %q",
+				opts.check, opts.strict, gotErr, got.String(), gotDiag.String(), wantErr, want.String(), wantDiag.String())
+		}
+	}
+}
+
+func TestProcessWindowedLineLongerThanWindow(t *testing.T) {
+	contents := "A;1.0\n" + strings.Repeat("x", 10000) + ";1.0\n"
+	var out bytes.Buffer
+	if err := process(&out, writeTempFile(t, contents), options{workers: 1, windowSize: 4096}); err == nil {
+		t.Error("expected an error for a line longer than the window")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+const (
+	// autoTrialMaxBytes caps the prefix -workers=auto benchmarks on
+	autoTrialMaxBytes = 64 << 20
+
+	// autoTrialMinBytes is the smallest prefix worth timing; below it the
+	// whole file is processed with NumCPU workers
+	autoTrialMinBytes = 1 << 20
+)
+
+// processAutoWorkers implements -workers=auto. The job is usually memory
+// bandwidth bound, so past some point extra workers only add contention.
+// To find that point it times a newline-aligned prefix of data[start:end]
+// (an eighth of it, capped at autoTrialMaxBytes) with 1, NumCPU/4,
+// NumCPU/2 and NumCPU workers, keeps the fastest trial's results for the
+// prefix and processes the rest with that many workers.
+//
+// The prefix is paged in before the first trial so the trials are compared
+// on a warm cache. The trials run without -throttle, -stream-partials and
+// -trace-station, so only the kept trial is reported and charged to the
+// throttle, once. The count chosen is kept in opts for the later windows
+// of a windowed or streamed run, which aren't timed again. An explicit
+// -workers=N skips all of this. start must be past any byte order mark.
+func processAutoWorkers(data []byte, start, end int, opts *options) []*chunkResult {
+	numCPU := runtime.NumCPU()
+	eol := opts.eol()
+
+	prefixEnd := start + (end-start)/8
+	if prefixEnd > start+autoTrialMaxBytes {
+		prefixEnd = start + autoTrialMaxBytes
+	}
+	if prefixEnd-start < autoTrialMinBytes {
+		return runWorkers(data, splitBlocks(data, start, end, numCPU, eol), opts)
+	}
+	for prefixEnd < end && data[prefixEnd-1] != eol {
+		prefixEnd++
+	}
+
+	prefaultPages(data[:prefixEnd])
+
+	trialOpts := *opts
+	trialOpts.throttle, trialOpts.partials, trialOpts.trace = nil, nil, nil
+	var (
+		best        int
+		bestElapsed time.Duration
+		bestBlocks  []block
+		bestResults []*chunkResult
+	)
+	for _, n := range autoWorkerCandidates(numCPU) {
+		blocks := splitBlocks(data, start, prefixEnd, n, eol)
+		began := time.Now()
+		results := runWorkers(data, blocks, &trialOpts)
+		elapsed := time.Since(began)
+
+		opts.logf("workers=auto: %d workers took %v over %d bytes", n, elapsed, prefixEnd-start)
+		if bestResults == nil || elapsed < bestElapsed {
+			best, bestElapsed, bestBlocks, bestResults = n, elapsed, blocks, results
+		}
+	}
+	if opts.throttle != nil {
+		opts.throttle.wait(prefixEnd - start)
+	}
+	for i := range bestResults {
+		opts.partials.report(i, bestResults[i])
+		opts.trace.report(i, &bestBlocks[i], bestResults[i])
+	}
+
+	opts.logf("workers=auto: using %d workers", best)
+	opts.workers, opts.autoWorkers = best, false
+	rest := runWorkers(data, splitBlocks(data, prefixEnd, end, best, eol), opts)
+	return append(bestResults, rest...)
+}
+
+// autoWorkerCandidates returns the distinct worker counts tried by
+// -workers=auto, in increasing order.
+func autoWorkerCandidates(numCPU int) []int {
+	var candidates []int
+	for _, n := range []int{1, numCPU / 4, numCPU / 2, numCPU} {
+		if n < 1 || (len(candidates) > 0 && n <= candidates[len(candidates)-1]) {
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	return candidates
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAutoWorkerCandidates(t *testing.T) {
+	tests := map[int][]int{
+		1:  {1},
+		2:  {1, 2},
+		4:  {1, 2, 4},
+		16: {1, 4, 8, 16},
+	}
+	for numCPU, want := range tests {
+		if got := autoWorkerCandidates(numCPU); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("autoWorkerCandidates(%d) = %v, want %v", numCPU, got, want)
+		}
+	}
+}
+
+// autoWorkersInput returns random measurements of at least size bytes,
+// enough for -workers=auto to time trials on.
+func autoWorkersInput(size int) string {
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		fmt.Fprintf(&buf, "Station%03d;%.1f\n", rng.Intn(413), rng.Float64()*199.8-99.9)
+	}
+	return buf.String()
+}
+
+func TestProcessAutoWorkersMatchesFixed(t *testing.T) {
+	contents := autoWorkersInput(9 * autoTrialMinBytes)
+	want := runProcess(t, contents, options{workers: 1})
+	got := runProcess(t, contents, options{workers: runtime.NumCPU(), autoWorkers: true})
+	if got != want {
+		t.Error("-workers=auto output differs from a single worker")
+	}
+
+	// The trials beyond the one kept aren't traced, so the traced rows add
+	// up to the station's total
+	var diag bytes.Buffer
+	runProcess(t, contents, options{workers: runtime.NumCPU(), autoWorkers: true, trace: newStationTrace(&diag, "Station001")})
+	traced := 0
+	for _, line := range strings.Split(strings.TrimSuffix(diag.String(), "\n"), "\n") {
+		if _, count, ok := strings.Cut(line, " count="); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				t.Fatalf("trace line %q: %v", line, err)
+			}
+			traced += n
+		}
+	}
+	if want := strings.Count(contents, "Station001;"); traced != want {
+		t.Errorf("traced %d rows, want %d", traced, want)
+	}
+
+	// A windowed run picks the count on its first window and keeps it
+	var log bytes.Buffer
+	contents = autoWorkersInput(12 * autoTrialMinBytes)
+	want = runProcess(t, contents, options{workers: 1})
+	got = runProcess(t, contents, options{workers: runtime.NumCPU(), autoWorkers: true, windowSize: 10 * autoTrialMinBytes, verbose: true, diag: &log})
+	if got != want {
+		t.Error("windowed -workers=auto output differs from a single worker")
+	}
+	if n := strings.Count(log.String(), "workers=auto: using"); n != 1 {
+		t.Errorf("windowed: chose a worker count %d times, want once:\n%s", n, log.String())
+	}
+}